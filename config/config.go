@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"spirit-box/device"
 	"spirit-box/logging"
 	"spirit-box/scripts"
 	"spirit-box/services"
+	"strconv"
+	"time"
 )
 
 // Path for directory that stores config files and logs. Defaults to /etc/spirit-box/.
@@ -20,9 +23,15 @@ var DEBUG_FILE string
 // Defaults to false.
 var TUI_FANCY bool
 
+// Path to a fake systemd script. Runs against an in-memory systemd instead of D-Bus if set.
+var FAKE_SYSTEMD string
+
 // Permission for user to view expanded info on systemd units.
 var SYSTEMD_ACCESS bool
 
+// Permission for user to start, stop and restart watched units.
+var UNIT_ACTIONS bool
+
 // Message to display when system is ready.
 var BANNER_MESSAGE string
 
@@ -49,26 +58,56 @@ type ParseObj struct {
 	HostPort       string               `json:"hostPort"`
 	TempPort       string               `json:"tempPort"`
 	Nic            string               `json:"nic"`
+	Proxy          string               `json:"proxy"`
+	Firewall       string               `json:"firewall"`
 	SystemdAccess  string               `json:"systemdAccess"`
+	UnitActions    string               `json:"unitActions"`
 	BannerMessage  string               `json:"bannerMessage"`
 	Enabled        string               `json:"enabled"`
 	UnitSpecArr    []services.UnitSpec  `json:"unitSpecs"`
 	ScriptSpecArr  []scripts.ScriptSpec `json:"scriptSpecs"`
 	ConfigOverride string               `json:"configOverride"`
+	FlapThreshold  string               `json:"flapThreshold"`
+	FlapWindow     string               `json:"flapWindow"`
+	FlapSettleTime string               `json:"flapSettleTime"`
+	JournalLines   string               `json:"journalLines"`
+	// nil if omitted, an explicit empty list clears the default
+	PropertyAllowlist []string `json:"propertyAllowlist"`
+	PropertyDenylist  []string `json:"propertyDenylist"`
+	RedactExecArgs    string   `json:"redactExecArgs"`
+	HistoryProperties []string `json:"historyProperties"`
+	HistoryLength     string   `json:"historyLength"`
+	RuntimeOverride   string   `json:"runtimeOverride"`
+	RemovedUnits      []string `json:"removedUnits"` // unit specs to drop, written to the runtime override
+
+	// readiness probe of the host application, see device.HOST_PROBE
+	HostProbe         string `json:"hostProbe"`
+	HostProbeStatus   string `json:"hostProbeStatus"`
+	HostProbeInterval string `json:"hostProbeInterval"`
+	HostProbeTimeout  string `json:"hostProbeTimeout"`
+
+	PortMappings []device.PortMapping `json:"portMappings"` // in addition to hostPort and tempPort
+
+	AddrWaitTimeout string `json:"addrWaitTimeout"` // see device.ADDR_WAIT_TIMEOUT
+	DNSTestName     string `json:"dnsTestName"`     // "none" skips the DNS check
+	MDNS            string `json:"mdns"`
 }
 
-func LoadConfig() {
+// Override files that were loaded, in order.
+var loadedOverrides []string
+
+func LoadConfig() error {
 	initPaths()
 	configObj := ParseObj{}
 
 	bytes, err := os.ReadFile(CONFIG_PATH)
 	if err != nil {
-		log.Fatal(fmt.Errorf("Loading config from %s: %s", CONFIG_PATH, err.Error()))
+		return fmt.Errorf("Loading config from %s: %s", CONFIG_PATH, err.Error())
 	}
 
 	err = json.Unmarshal(bytes, &configObj)
 	if err != nil {
-		log.Fatal(fmt.Errorf("Loading config from %s: %s", CONFIG_PATH, err.Error()))
+		return fmt.Errorf("Loading config from %s: %s", CONFIG_PATH, err.Error())
 	}
 	log.Printf("Successfully loaded config from %s.", CONFIG_PATH)
 
@@ -82,17 +121,110 @@ func LoadConfig() {
 	if configObj.SystemdAccess == "true" {
 		SYSTEMD_ACCESS = true
 	}
+	services.SYSTEMD_ACCESS = SYSTEMD_ACCESS
+	if configObj.UnitActions == "true" {
+		UNIT_ACTIONS = true
+	}
+	services.UNIT_ACTIONS = UNIT_ACTIONS
 	BANNER_MESSAGE = configObj.BannerMessage
 
 	logging.LOG_PATH = LOG_PATH
 
 	device.SERVER_PORT = configObj.ServerPort
-	device.HOST_PORT = configObj.HostPort
-	device.TEMP_PORT = configObj.TempPort
-	device.NIC = configObj.Nic
+	mappings := make([]device.PortMapping, 0, len(configObj.PortMappings)+1)
+	if configObj.HostPort != "" {
+		mappings = append(mappings, device.PortMapping{HostPort: configObj.HostPort, TempPort: configObj.TempPort})
+	}
+	device.SetPortMappings(append(mappings, configObj.PortMappings...))
+	device.SetNics(configObj.Nic)
+	if configObj.Proxy == "true" {
+		device.PROXY = true
+	}
+	if err := device.SetFirewall(configObj.Firewall); err != nil {
+		return err
+	}
+	device.HOST_PROBE = configObj.HostProbe
+	if configObj.HostProbeStatus != "" {
+		device.HOST_PROBE_STATUS = parseInt("hostProbeStatus", configObj.HostProbeStatus, device.HOST_PROBE_STATUS)
+	}
+	if configObj.HostProbeInterval != "" {
+		device.HOST_PROBE_INTERVAL = parseMillis("hostProbeInterval", configObj.HostProbeInterval, device.HOST_PROBE_INTERVAL)
+	}
+	if configObj.HostProbeTimeout != "" {
+		device.HOST_PROBE_TIMEOUT = parseMillis("hostProbeTimeout", configObj.HostProbeTimeout, device.HOST_PROBE_TIMEOUT)
+	}
+	if configObj.AddrWaitTimeout != "" {
+		device.ADDR_WAIT_TIMEOUT = parseMillis("addrWaitTimeout", configObj.AddrWaitTimeout, device.ADDR_WAIT_TIMEOUT)
+	}
+	if configObj.MDNS == "false" {
+		device.MDNS = false
+	}
+	if configObj.DNSTestName == "none" {
+		device.DNS_TEST_NAME = ""
+	} else if configObj.DNSTestName != "" {
+		device.DNS_TEST_NAME = configObj.DNSTestName
+	}
 
 	scripts.SCRIPT_SPECS = configObj.ScriptSpecArr
 	services.UNIT_SPECS = configObj.UnitSpecArr
+
+	if configObj.FlapThreshold != "" {
+		services.FLAP_THRESHOLD = parseInt("flapThreshold", configObj.FlapThreshold, services.FLAP_THRESHOLD)
+	}
+	if configObj.FlapWindow != "" {
+		services.FLAP_WINDOW = parseMillis("flapWindow", configObj.FlapWindow, services.FLAP_WINDOW)
+	}
+	if configObj.FlapSettleTime != "" {
+		services.FLAP_SETTLE_TIME = parseMillis("flapSettleTime", configObj.FlapSettleTime, services.FLAP_SETTLE_TIME)
+	}
+	if configObj.JournalLines != "" {
+		services.JOURNAL_LINES = parseInt("journalLines", configObj.JournalLines, services.JOURNAL_LINES)
+	}
+	if configObj.PropertyAllowlist != nil {
+		services.PROPERTY_ALLOWLIST = configObj.PropertyAllowlist
+	}
+	if configObj.PropertyDenylist != nil {
+		services.PROPERTY_DENYLIST = configObj.PropertyDenylist
+	}
+	if configObj.RedactExecArgs == "false" {
+		services.REDACT_EXEC_ARGS = false
+	}
+	if configObj.HistoryProperties != nil {
+		services.HISTORY_PROPERTIES = configObj.HistoryProperties
+	}
+	if configObj.HistoryLength != "" {
+		services.HISTORY_LENGTH = parseInt("historyLength", configObj.HistoryLength, services.HISTORY_LENGTH)
+	}
+
+	services.RUNTIME_OVERRIDE = configObj.RuntimeOverride
+	if configObj.RuntimeOverride != "" && !isLoadedOverride(configObj.RuntimeOverride) {
+		log.Printf("Runtime override %s is not part of the configOverride chain, units added or removed at run time won't be restored.", configObj.RuntimeOverride)
+	}
+	return nil
+}
+
+// Falls back to the default value if the field can't be parsed.
+func parseInt(field, val string, def int) int {
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("Parsing %s: %s. Using default value %d.", field, err.Error(), def)
+		return def
+	}
+	return n
+}
+
+// Parses a number of milliseconds.
+func parseMillis(field, val string, def time.Duration) time.Duration {
+	return time.Duration(parseInt(field, val, int(def.Milliseconds()))) * time.Millisecond
+}
+
+func isLoadedOverride(configPath string) bool {
+	for _, p := range loadedOverrides {
+		if filepath.Clean(p) == filepath.Clean(configPath) {
+			return true
+		}
+	}
+	return false
 }
 
 func loadConfigRecursive(configObj *ParseObj, configPath string) {
@@ -119,6 +251,7 @@ func loadConfigRecursive(configObj *ParseObj, configPath string) {
 		return
 	}
 	log.Printf("Successfully loaded config from %s.", configPath)
+	loadedOverrides = append(loadedOverrides, configPath)
 
 	joinConfigs(configObj, &temp)
 	loadConfigRecursive(configObj, temp.ConfigOverride)
@@ -135,18 +268,99 @@ func joinConfigs(configObj *ParseObj, overrides *ParseObj) {
 	if overrides.TempPort != "" {
 		configObj.TempPort = overrides.TempPort
 	}
+	if overrides.PortMappings != nil {
+		configObj.PortMappings = overrides.PortMappings
+	}
 	if overrides.Nic != "" {
 		configObj.Nic = overrides.Nic
 	}
+	if overrides.Proxy != "" {
+		configObj.Proxy = overrides.Proxy
+	}
+	if overrides.Firewall != "" {
+		configObj.Firewall = overrides.Firewall
+	}
+	if overrides.HostProbe != "" {
+		configObj.HostProbe = overrides.HostProbe
+	}
+	if overrides.HostProbeStatus != "" {
+		configObj.HostProbeStatus = overrides.HostProbeStatus
+	}
+	if overrides.HostProbeInterval != "" {
+		configObj.HostProbeInterval = overrides.HostProbeInterval
+	}
+	if overrides.HostProbeTimeout != "" {
+		configObj.HostProbeTimeout = overrides.HostProbeTimeout
+	}
+	if overrides.AddrWaitTimeout != "" {
+		configObj.AddrWaitTimeout = overrides.AddrWaitTimeout
+	}
+	if overrides.DNSTestName != "" {
+		configObj.DNSTestName = overrides.DNSTestName
+	}
+	if overrides.MDNS != "" {
+		configObj.MDNS = overrides.MDNS
+	}
 	if overrides.SystemdAccess != "" {
 		configObj.SystemdAccess = overrides.SystemdAccess
 	}
+	if overrides.UnitActions != "" {
+		configObj.UnitActions = overrides.UnitActions
+	}
 	if overrides.BannerMessage != "" {
 		configObj.BannerMessage = overrides.BannerMessage
 	}
 	if overrides.Enabled != "" {
 		configObj.Enabled = overrides.Enabled
 	}
+	if overrides.FlapThreshold != "" {
+		configObj.FlapThreshold = overrides.FlapThreshold
+	}
+	if overrides.FlapWindow != "" {
+		configObj.FlapWindow = overrides.FlapWindow
+	}
+	if overrides.FlapSettleTime != "" {
+		configObj.FlapSettleTime = overrides.FlapSettleTime
+	}
+	if overrides.JournalLines != "" {
+		configObj.JournalLines = overrides.JournalLines
+	}
+	if overrides.PropertyAllowlist != nil {
+		configObj.PropertyAllowlist = overrides.PropertyAllowlist
+	}
+	if overrides.PropertyDenylist != nil {
+		configObj.PropertyDenylist = overrides.PropertyDenylist
+	}
+	if overrides.RedactExecArgs != "" {
+		configObj.RedactExecArgs = overrides.RedactExecArgs
+	}
+	if overrides.HistoryProperties != nil {
+		configObj.HistoryProperties = overrides.HistoryProperties
+	}
+	if overrides.HistoryLength != "" {
+		configObj.HistoryLength = overrides.HistoryLength
+	}
+	if overrides.RuntimeOverride != "" {
+		configObj.RuntimeOverride = overrides.RuntimeOverride
+	}
+
+	// removals come first, so an override file can remove a unit and add it back with a new spec
+	if len(overrides.RemovedUnits) > 0 {
+		specs := make([]services.UnitSpec, 0, len(configObj.UnitSpecArr))
+		for _, spec := range configObj.UnitSpecArr {
+			removed := false
+			for _, name := range overrides.RemovedUnits {
+				if spec.Name == name {
+					removed = true
+					break
+				}
+			}
+			if !removed {
+				specs = append(specs, spec)
+			}
+		}
+		configObj.UnitSpecArr = specs
+	}
 
 	if len(overrides.UnitSpecArr) > 0 {
 		for _, spec := range overrides.UnitSpecArr {
@@ -7,107 +7,245 @@ import (
 	"net"
 	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var SERVER_PORT = "8080" // spirit-box server port
-var HOST_PORT = "80"     // port that host machine's default server uses
+var HOST_PORT = "80"     // port that host machine's default server uses, the first of PORT_MAPPINGS
 var TEMP_PORT = "8081"   // port to use redirect HOST_PORT to while waiting for that server to come up
-var NIC = "eth0"         // nic to set iptables rules for
-var HOST_IS_UP = false
 
-func PrintInterfaces() {
+// 1 once the host ports are handed back to the host machine's servers. Read by the proxy and
+// the web server concurrently, use HostIsUp and SetHostIsUp.
+var hostIsUp int32
+
+func HostIsUp() bool {
+	return atomic.LoadInt32(&hostIsUp) == 1
+}
+
+func SetHostIsUp() {
+	atomic.StoreInt32(&hostIsUp, 1)
+}
+
+// nics to set firewall rules for and show addresses of. nil means all interfaces.
+var NICS = []string{"eth0"}
+
+// A port of the host machine's servers and the port it can be reached on while it is redirected.
+type PortMapping struct {
+	HostPort string `json:"hostPort"`
+	TempPort string `json:"tempPort"`
+	TLS      string `json:"tls,omitempty"` // "true" if the host application serves TLS on this port
+}
+
+// Mappings without a tls setting are TLS if their host port is 443.
+func (m PortMapping) IsTLS() bool {
+	if m.TLS == "" {
+		return m.HostPort == "443"
+	}
+	return m.TLS == "true"
+}
+
+var PORT_MAPPINGS = []PortMapping{{HostPort: HOST_PORT, TempPort: TEMP_PORT}}
+
+// Sets NICS from a comma separated list of interfaces, or "all".
+func SetNics(nic string) {
+	if strings.TrimSpace(nic) == "all" {
+		NICS = nil
+		return
+	}
+	NICS = make([]string, 0)
+	for _, name := range strings.Split(nic, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			NICS = append(NICS, name)
+		}
+	}
+}
+
+// Sets PORT_MAPPINGS, HOST_PORT and TEMP_PORT are set from the first mapping.
+func SetPortMappings(mappings []PortMapping) {
+	if len(mappings) == 0 {
+		return
+	}
+	PORT_MAPPINGS = mappings
+	HOST_PORT, TEMP_PORT = mappings[0].HostPort, mappings[0].TempPort
+}
+
+// Names of the interfaces in NICS, or of all interfaces except loopback if it is nil.
+func Nics() []string {
+	if NICS != nil {
+		return NICS
+	}
 	interfaces, err := net.Interfaces()
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		return []string{}
 	}
+	names := make([]string, 0, len(interfaces))
 	for _, i := range interfaces {
-		var ips []string
-		ipv4_exists := false // keep checking until interface has ipv4 address
-		for {
-			ips = make([]string, 0)
-			addrs, err := i.Addrs()
-			if err != nil {
-				log.Fatal(err)
-			}
-			for _, addr := range addrs {
-				str := addr.String()
-				ips = append(ips, str)
-				ipv4_exists = ipv4_exists || isIPv4(str)
-			}
-			if ipv4_exists {
-				break
-			}
+		if i.Flags&net.FlagLoopback == 0 {
+			names = append(names, i.Name)
 		}
-		out := fmt.Sprintf("%s: %s\n", i.Name, strings.Join(ips, ", "))
-		fmt.Print(out)
 	}
-	fmt.Println()
+	return names
 }
 
-func GetIPv4Addr(interfaceName string) string {
+func PrintInterfaces() {
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		log.Fatal(err)
 	}
 	for _, i := range interfaces {
-		if i.Name != interfaceName {
-			continue
+		ips := make([]string, 0)
+		addrs, err := i.Addrs()
+		if err != nil {
+			log.Fatal(err)
 		}
-
-		for { // IPs are not always available immediately. unsafe workaround that should be changed.
-			addrs, err := i.Addrs()
-			if err != nil {
-				log.Fatal(err)
-			}
-			for _, addr := range addrs {
-				str := addr.String()
-				if isIPv4(str) {
-					return str
-				}
-			}
+		for _, addr := range addrs {
+			ips = append(ips, addr.String())
 		}
+		out := fmt.Sprintf("%s: %s\n", i.Name, strings.Join(ips, ", "))
+		fmt.Print(out)
 	}
-	return "Unable to find IP."
+	fmt.Println()
 }
 
+// Addresses of an interface with their prefix length, IPv4 addresses first.
+// Link-local IPv6 addresses are left out.
 func GetAddrs(interfaceName string) ([]string, error) {
-	i, err := net.InterfaceByName(interfaceName)
+	addrs, err := GetNicAddrs(interfaceName)
 	if err != nil {
 		return nil, err
 	}
+	return append(addrs.IPv4, addrs.IPv6...), nil
+}
+
+type NicAddrs struct {
+	Nic  string   `json:"nic"`
+	IPv4 []string `json:"ipv4"`
+	IPv6 []string `json:"ipv6"` // global addresses only
+}
+
+func GetNicAddrs(interfaceName string) (NicAddrs, error) {
+	ret := NicAddrs{Nic: interfaceName, IPv4: []string{}, IPv6: []string{}}
+	i, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return ret, err
+	}
 
-	ret := []string{}
 	addrs, err := i.Addrs()
 	if err != nil {
-		return nil, err
+		return ret, err
 	}
 
 	for _, addr := range addrs {
-		address := addr.String()
-		if !isLinkLocalIPv6(address) {
-			ret = append(ret, address)
+		ip := addrIP(addr)
+		if isIPv4(ip) {
+			ret.IPv4 = append(ret.IPv4, addr.String())
+		} else if isGlobalIPv6(ip) {
+			ret.IPv6 = append(ret.IPv6, addr.String())
 		}
 	}
 
 	return ret, nil
 }
 
+// Addresses of every interface in Nics().
+func GetAllNicAddrs() []NicAddrs {
+	ret := make([]NicAddrs, 0)
+	for _, nic := range Nics() {
+		addrs, err := GetNicAddrs(nic)
+		if err != nil {
+			log.Print(err)
+		}
+		ret = append(ret, addrs)
+	}
+	return ret
+}
+
 func CreateIPStr() string {
-	ips, err := GetAddrs(NIC)
+	nics := Nics()
+	nicStrs := make([]string, 0, len(nics))
+	for _, nic := range nics {
+		ips, err := GetAddrs(nic)
+		waiting := AddrWaiting()
+		switch {
+		case err != nil && waiting:
+			ips = []string{"waiting for interface"}
+		case err != nil:
+			ips = []string{"not found"}
+		case len(ips) == 0 && waiting:
+			ips = []string{"waiting for address"}
+		case len(ips) == 0:
+			ips = []string{"no address"}
+		}
+		if err == nil && LinkState(nic) == "down" {
+			ips = append(ips, "link down")
+		}
+		if len(nics) == 1 {
+			nicStrs = append(nicStrs, strings.Join(ips, ", "))
+		} else {
+			nicStrs = append(nicStrs, fmt.Sprintf("%s %s", nic, strings.Join(ips, ", ")))
+		}
+	}
+
+	ports := make([]string, 0, len(PORT_MAPPINGS))
+	for _, m := range PORT_MAPPINGS {
+		ports = append(ports, fmt.Sprintf("%s (temp %s)", m.HostPort, m.TempPort))
+	}
+	return fmt.Sprintf("IP: %s\nPorts: host -> %s, spirit-box server -> %s",
+		strings.Join(nicStrs, "; "), strings.Join(ports, ", "), SERVER_PORT)
+}
+
+// URL of the dashboard on HOST_PORT, with the first IPv4 address of Nics(), or their first
+// global IPv6 address if none has one. "" while no nic has an address.
+func DashboardURL() string {
+	var ipv6 net.IP
+	for _, nic := range Nics() {
+		addrs, err := GetNicAddrs(nic)
+		if err != nil {
+			continue
+		}
+		if len(addrs.IPv4) > 0 {
+			ip, _, _ := net.ParseCIDR(addrs.IPv4[0])
+			return fmt.Sprintf("http://%s", net.JoinHostPort(ip.String(), HOST_PORT))
+		}
+		if len(addrs.IPv6) > 0 && ipv6 == nil {
+			ipv6, _, _ = net.ParseCIDR(addrs.IPv6[0])
+		}
+	}
+	if ipv6 != nil {
+		return fmt.Sprintf("http://%s", net.JoinHostPort(ipv6.String(), HOST_PORT))
+	}
+	return ""
+}
+
+// The IP of an interface address, nil if it can't be parsed.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPNet:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	ip, _, err := net.ParseCIDR(addr.String())
 	if err != nil {
-		log.Print(err)
-		ips = []string{"not found"}
+		return net.ParseIP(addr.String())
 	}
-	return fmt.Sprintf("IP: %s\nPorts: host -> %s, spirit-box server -> %s", strings.Join(ips, ", "), HOST_PORT, SERVER_PORT)
+	return ip
 }
 
-func isIPv4(address string) bool {
-	return strings.Count(address, ":") < 2
+func isIPv4(ip net.IP) bool {
+	return ip != nil && ip.To4() != nil
 }
 
-func isLinkLocalIPv6(address string) bool {
-	return strings.HasPrefix(address, "fe80")
+// Global unicast, including unique local addresses (fc00::/7), which are what most appliances get on isolated networks.
+func isGlobalIPv6(ip net.IP) bool {
+	return ip != nil && ip.To4() == nil && ip.IsGlobalUnicast()
+}
+
+func isLinkLocalIPv6(ip net.IP) bool {
+	return ip != nil && ip.To4() == nil && ip.IsLinkLocalUnicast()
 }
 
 func Stub() {
@@ -144,41 +282,121 @@ func Stub() {
 	fmt.Printf("Unicast:\n")
 	for _, addr := range uni {
 		address, network := addr.String(), addr.Network()
-		fmt.Printf("%s: %s, IsLinkLocalIPv6: %t\n", address, network, isLinkLocalIPv6(address))
+		fmt.Printf("%s: %s, IsLinkLocalIPv6: %t\n", address, network, isLinkLocalIPv6(addrIP(addr)))
 	}
 
 	fmt.Printf("Multicast:\n")
 	for _, addr := range multi {
 		address, network := addr.String(), addr.Network()
-		fmt.Printf("%s: %s, IsLinkLocalIPv6: %t\n", address, network, isLinkLocalIPv6(address))
+		fmt.Printf("%s: %s, IsLinkLocalIPv6: %t\n", address, network, isLinkLocalIPv6(addrIP(addr)))
 	}
 }
 
-func SetRules(addFlag, nic, from, to string) error {
-	// -A or -D for addFlag
-	args := strings.Split(
-		fmt.Sprintf("-t nat %s PREROUTING -i %s -p tcp --dport %s -j REDIRECT --to %s", addFlag, nic, from, to), " ")
-	cmd := exec.Command("iptables", args...)
-	bytes, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("Error setting iptables rule: %w: %s, %v", err, string(bytes), args)
+// Comment that marks the firewall rules spirit-box creates, so they can be told apart from others.
+const RULE_TAG = "spirit-box"
+
+// The tagged REDIRECT rules for one port, in the nat table.
+func iptablesRules(nics []string, from, to string) [][]string {
+	tag := []string{"-m", "comment", "--comment", RULE_TAG, "-j", "REDIRECT", "--to-ports", to}
+	rules := make([][]string, 0, len(nics)+2)
+	for _, nic := range nics {
+		rules = append(rules, append([]string{"PREROUTING", "-i", nic, "-p", "tcp", "--dport", from}, tag...))
 	}
+	if nics == nil { // all interfaces
+		rules = append(rules, append([]string{"PREROUTING", "-p", "tcp", "--dport", from}, tag...))
+	}
+	return append(rules, append([]string{"OUTPUT", "-p", "tcp", "--dport", from}, tag...))
+}
+
+var ip6tablesOnce sync.Once
+var ip6tablesNat bool
+
+// iptables, and ip6tables if it is installed and the kernel has an IPv6 nat table.
+func iptablesBinaries() []string {
+	ip6tablesOnce.Do(func() {
+		if _, err := exec.LookPath("ip6tables"); err != nil {
+			return
+		}
+		if out, err := exec.Command("ip6tables", "-w", "-t", "nat", "-S").CombinedOutput(); err != nil {
+			log.Printf("Warning: ip6tables has no nat table, only IPv4 is redirected. (%s)", lastLine(out, err))
+			return
+		}
+		ip6tablesNat = true
+	})
+	if ip6tablesNat {
+		return []string{"iptables", "ip6tables"}
+	}
+	return []string{"iptables"}
+}
 
-	args = strings.Split(
-		fmt.Sprintf("-t nat %s OUTPUT -p tcp --dport %s -j REDIRECT --to %s", addFlag, from, to), " ")
-	cmd = exec.Command("iptables", args...)
-	bytes, err = cmd.Output()
+func iptables(bin string, args ...string) ([]byte, error) {
+	// -w waits for the xtables lock instead of failing while another program holds it
+	args = append([]string{"-w", "-t", "nat"}, args...)
+	out, err := exec.Command(bin, args...).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("Error setting iptables rule: %w: %s, %v", err, string(bytes), args)
+		return out, fmt.Errorf("Error setting %s rule: %w: %s, %v", bin, err, string(out), args)
 	}
+	return out, nil
+}
 
+// Adds (-A) or deletes (-D) the redirect rules for IPv4 and IPv6. Rules that already
+// exist aren't added again, and deleting rules that don't exist is not an error.
+func SetRules(addFlag string, nics []string, from, to string) error {
+	for _, bin := range iptablesBinaries() {
+		for _, rule := range iptablesRules(nics, from, to) {
+			_, checkErr := iptables(bin, append([]string{"-C"}, rule...)...)
+			exists := checkErr == nil
+			if addFlag == "-A" && exists || addFlag == "-D" && !exists {
+				continue
+			}
+			if _, err := iptables(bin, append([]string{addFlag}, rule...)...); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func SetPortForwarding() error {
+// Deletes every rule tagged with RULE_TAG, e.g. those left behind by a crashed run.
+func sweepIptables() error {
+	for _, bin := range iptablesBinaries() {
+		for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+			out, err := iptables(bin, "-S", chain)
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(out), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) < 2 || fields[0] != "-A" || !hasTag(fields) {
+					continue
+				}
+				fields[0] = "-D"
+				for i := range fields {
+					fields[i] = strings.Trim(fields[i], `"`)
+				}
+				if _, err := iptables(bin, fields...); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasTag(fields []string) bool {
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "--comment" && strings.Trim(fields[i+1], `"`) == RULE_TAG {
+			return true
+		}
+	}
+	return false
+}
+
+// Redirects m.HostPort to spirit-box, and m.TempPort to the host's server.
+func SetMapping(m PortMapping) error {
 	var err error
 	for i := 0; i < 10; i++ {
-		err = SetRules("-A", NIC, TEMP_PORT, HOST_PORT)
+		err = FIREWALL.AddRedirect(NICS, m.TempPort, m.HostPort)
 		if err == nil {
 			break
 		}
@@ -189,7 +407,7 @@ func SetPortForwarding() error {
 	}
 
 	for i := 0; i < 10; i++ {
-		err = SetRules("-A", NIC, HOST_PORT, SERVER_PORT)
+		err = FIREWALL.AddRedirect(NICS, m.HostPort, SERVER_PORT)
 		if err == nil {
 			break
 		}
@@ -198,10 +416,42 @@ func SetPortForwarding() error {
 	return err
 }
 
-func UnsetPortForwarding() error {
-	err := SetRules("-D", NIC, HOST_PORT, SERVER_PORT)
+func UnsetMapping(m PortMapping) error {
+	err := FIREWALL.RemoveRedirect(NICS, m.HostPort, SERVER_PORT)
 	if err != nil {
 		return err
 	}
-	return SetRules("-D", NIC, TEMP_PORT, HOST_PORT)
+	return FIREWALL.RemoveRedirect(NICS, m.TempPort, m.HostPort)
+}
+
+func SetPortForwarding() error {
+	for _, m := range PORT_MAPPINGS {
+		if err := SetMapping(m); err != nil {
+			return fmt.Errorf("Redirecting port %s: %w", m.HostPort, err)
+		}
+	}
+	return nil
+}
+
+// Removes all redirect rules spirit-box created, including those of earlier runs.
+func SweepPortForwarding() error {
+	err := FIREWALL.Sweep()
+	if err != nil {
+		return fmt.Errorf("Removing stale %s rules: %w", FIREWALL.Name(), err)
+	}
+	return nil
+}
+
+// Removes the rules of every mapping, even if removing an earlier one failed.
+func UnsetPortForwarding() error {
+	var firstErr error
+	for _, m := range PORT_MAPPINGS {
+		if err := UnsetMapping(m); err != nil {
+			log.Printf("Removing redirect of port %s: %s", m.HostPort, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
 }
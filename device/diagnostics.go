@@ -0,0 +1,281 @@
+// Network diagnostics for when the web UI can't be reached: links, the default route and DNS.
+package device
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var SYS_CLASS_NET = "/sys/class/net"
+var PROC_NET_ROUTE = "/proc/net/route"
+var PROC_NET_ARP = "/proc/net/arp"
+var RESOLV_CONF = "/etc/resolv.conf"
+
+// Name that is resolved to check DNS. "" skips the check.
+var DNS_TEST_NAME = "example.com"
+
+// Time the gateway and the DNS servers have to answer.
+var DIAGNOSTICS_TIMEOUT = 2 * time.Second
+
+type LinkInfo struct {
+	Nic   string `json:"nic"`
+	State string `json:"state"` // operstate, e.g. up, down or unknown
+	Speed int    `json:"speed"` // Mbit/s, 0 if the driver doesn't report it or the link is down
+	MAC   string `json:"mac"`
+}
+
+type RouteInfo struct {
+	Nic     string `json:"nic"`
+	Gateway string `json:"gateway"`
+	Answers bool   `json:"answers"`
+	Error   string `json:"error,omitempty"`
+	metric  int
+}
+
+type DNSInfo struct {
+	Servers  []string `json:"servers"`
+	TestName string   `json:"testName"`
+	Resolves bool     `json:"resolves"`
+	Addrs    []string `json:"addrs"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type Diagnostics struct {
+	Time         time.Time  `json:"time"`
+	Links        []LinkInfo `json:"links"`
+	DefaultRoute *RouteInfo `json:"defaultRoute"` // nil if there is no IPv4 default route
+	RouteError   string     `json:"routeError,omitempty"`
+	DNS          DNSInfo    `json:"dns"`
+}
+
+// Runs all checks. Takes up to DIAGNOSTICS_TIMEOUT, the gateway and DNS are checked in parallel.
+func Diagnose() Diagnostics {
+	d := Diagnostics{Time: time.Now(), Links: readLinks()}
+
+	var wg sync.WaitGroup
+	route, err := readDefaultRoute()
+	if err != nil {
+		d.RouteError = err.Error()
+	} else if route != nil {
+		d.DefaultRoute = route
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkGateway(route)
+		}()
+	}
+
+	d.DNS = readDNS()
+	if d.DNS.TestName != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resolveTestName(&d.DNS)
+		}()
+	}
+
+	wg.Wait()
+	return d
+}
+
+// Every interface except loopback.
+func readLinks() []LinkInfo {
+	links := make([]LinkInfo, 0)
+	entries, err := os.ReadDir(SYS_CLASS_NET)
+	if err != nil {
+		return links
+	}
+	for _, e := range entries {
+		nic := e.Name()
+		if nic == "lo" {
+			continue
+		}
+		link := LinkInfo{Nic: nic, State: readSysNet(nic, "operstate"), MAC: readSysNet(nic, "address")}
+		// reading speed fails with EINVAL while the link is down
+		if speed, err := strconv.Atoi(readSysNet(nic, "speed")); err == nil && speed > 0 {
+			link.Speed = speed
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+func readSysNet(nic, attr string) string {
+	val, err := os.ReadFile(filepath.Join(SYS_CLASS_NET, nic, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(val))
+}
+
+// The IPv4 default route with the lowest metric, nil if there is none.
+func readDefaultRoute() (*RouteInfo, error) {
+	f, err := os.Open(PROC_NET_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var best *RouteInfo
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&0x1 == 0 { // RTF_UP
+			continue
+		}
+		metric, _ := strconv.Atoi(fields[6])
+		if best != nil && best.metric <= metric {
+			continue
+		}
+		gateway, err := parseProcIP(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		best = &RouteInfo{Nic: fields[0], Gateway: gateway.String(), metric: metric}
+	}
+	return best, scanner.Err()
+}
+
+// /proc/net/route has addresses as hex in host byte order, which is little endian on every platform spirit-box runs on.
+func parseProcIP(hex string) (net.IP, error) {
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing address %q in %s: %w", hex, PROC_NET_ROUTE, err)
+	}
+	return net.IPv4(byte(v), byte(v>>8), byte(v>>16), byte(v>>24)), nil
+}
+
+// Pings the gateway. Without ping, a complete ARP entry shows that it answered recently.
+func checkGateway(route *RouteInfo) {
+	if route.Gateway == "0.0.0.0" { // directly connected
+		route.Answers = true
+		return
+	}
+	if _, err := exec.LookPath("ping"); err == nil {
+		wait := strconv.Itoa(int(DIAGNOSTICS_TIMEOUT.Seconds() + 0.5))
+		out, err := exec.Command("ping", "-c", "1", "-W", wait, "-I", route.Nic, route.Gateway).CombinedOutput()
+		if err != nil {
+			route.Error = fmt.Sprintf("ping: %s", lastLine(out, err))
+			return
+		}
+		route.Answers = true
+		return
+	}
+
+	if arpComplete(route.Gateway) {
+		route.Answers = true
+	} else {
+		route.Error = "ping is not installed and the gateway has no ARP entry."
+	}
+}
+
+func arpComplete(ip string) bool {
+	f, err := os.Open(PROC_NET_ARP)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == ip && fields[2] == "0x2" { // ATF_COM
+			return true
+		}
+	}
+	return false
+}
+
+func lastLine(out []byte, err error) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return last
+	}
+	return err.Error()
+}
+
+func readDNS() DNSInfo {
+	dns := DNSInfo{Servers: []string{}, TestName: DNS_TEST_NAME, Addrs: []string{}}
+	f, err := os.Open(RESOLV_CONF)
+	if err != nil { // shown as no servers
+		return dns
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			dns.Servers = append(dns.Servers, fields[1])
+		}
+	}
+	return dns
+}
+
+func resolveTestName(dns *DNSInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), DIAGNOSTICS_TIMEOUT)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, dns.TestName)
+	if err != nil {
+		dns.Error = err.Error()
+		return
+	}
+	dns.Resolves = true
+	dns.Addrs = addrs
+}
+
+// Plain text for the TUIs, one line per link.
+func (d Diagnostics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Links:\n")
+	if len(d.Links) == 0 {
+		fmt.Fprintf(&b, "  none found in %s\n", SYS_CLASS_NET)
+	}
+	for _, l := range d.Links {
+		speed := "speed unknown"
+		if l.Speed > 0 {
+			speed = fmt.Sprintf("%d Mb/s", l.Speed)
+		}
+		fmt.Fprintf(&b, "  %s: %s, %s, %s\n", l.Nic, l.State, speed, l.MAC)
+	}
+
+	switch {
+	case d.RouteError != "":
+		fmt.Fprintf(&b, "Default route: %s\n", d.RouteError)
+	case d.DefaultRoute == nil:
+		fmt.Fprintf(&b, "Default route: none\n")
+	case d.DefaultRoute.Answers:
+		fmt.Fprintf(&b, "Default route: via %s on %s, gateway answers\n", d.DefaultRoute.Gateway, d.DefaultRoute.Nic)
+	default:
+		fmt.Fprintf(&b, "Default route: via %s on %s, gateway doesn't answer (%s)\n",
+			d.DefaultRoute.Gateway, d.DefaultRoute.Nic, d.DefaultRoute.Error)
+	}
+
+	servers := "none"
+	if len(d.DNS.Servers) > 0 {
+		servers = strings.Join(d.DNS.Servers, ", ")
+	}
+	fmt.Fprintf(&b, "DNS servers: %s\n", servers)
+	switch {
+	case d.DNS.TestName == "":
+	case d.DNS.Resolves:
+		fmt.Fprintf(&b, "%s resolves to %s\n", d.DNS.TestName, strings.Join(d.DNS.Addrs, ", "))
+	default:
+		fmt.Fprintf(&b, "%s doesn't resolve: %s\n", d.DNS.TestName, d.DNS.Error)
+	}
+	return b.String()
+}
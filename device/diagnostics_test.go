@@ -0,0 +1,109 @@
+package device
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Points one of the overridable paths at a file in testdata until the test ends.
+func useFixture(t *testing.T, path *string, name string) {
+	old := *path
+	*path = filepath.Join("testdata", name)
+	t.Cleanup(func() { *path = old })
+}
+
+func TestReadDefaultRoute(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    *RouteInfo
+		wantErr bool
+	}{
+		{fixture: "route_default", want: &RouteInfo{Nic: "eth0", Gateway: "192.0.2.1"}},
+		{fixture: "route_metrics", want: &RouteInfo{Nic: "eth0", Gateway: "192.0.2.1", metric: 100}},
+		{fixture: "route_none", want: nil}, // the only default route isn't up
+		{fixture: "route_malformed", wantErr: true},
+		{fixture: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useFixture(t, &PROC_NET_ROUTE, tt.fixture)
+			got, err := readDefaultRoute()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProcIP(t *testing.T) {
+	tests := []struct {
+		hex     string
+		want    net.IP
+		wantErr bool
+	}{
+		{hex: "010200C0", want: net.IPv4(192, 0, 2, 1)},
+		{hex: "0164a8c0", want: net.IPv4(192, 168, 100, 1)},
+		{hex: "00000000", want: net.IPv4(0, 0, 0, 0)},
+		{hex: "0102G0C0", wantErr: true},
+		{hex: "1010200C0", wantErr: true}, // more than 32 bits
+		{hex: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseProcIP(tt.hex)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseProcIP(%q) err = %v, want error %v", tt.hex, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseProcIP(%q) = %v, want %v", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func TestArpComplete(t *testing.T) {
+	useFixture(t, &PROC_NET_ARP, "arp")
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "192.0.2.1", want: true},
+		{ip: "192.0.2.7", want: false}, // incomplete
+		{ip: "192.0.2.9", want: false}, // no entry
+		{ip: "IP", want: false},        // the header
+	}
+	for _, tt := range tests {
+		if got := arpComplete(tt.ip); got != tt.want {
+			t.Errorf("arpComplete(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	useFixture(t, &PROC_NET_ARP, "missing")
+	if arpComplete("192.0.2.1") {
+		t.Error("arpComplete without an ARP table = true")
+	}
+}
+
+func TestReadDNS(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []string
+	}{
+		{fixture: "resolv_comments", want: []string{"192.0.2.53", "2001:db8::53"}},
+		{fixture: "resolv_empty", want: []string{}},
+		{fixture: "missing", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useFixture(t, &RESOLV_CONF, tt.fixture)
+			want := DNSInfo{Servers: tt.want, TestName: DNS_TEST_NAME, Addrs: []string{}}
+			if got := readDNS(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Firewall backends that redirect the host port to spirit-box.
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Backend used by SetPortForwarding and UnsetPortForwarding. Set with SetFirewall.
+var FIREWALL Firewall = Iptables{}
+
+type Firewall interface {
+	Name() string
+	// Redirects tcp traffic for port from to port to, both arriving on nics and sent locally.
+	// nil nics means all interfaces. Adding a redirect that exists or removing one that doesn't is not an error.
+	AddRedirect(nics []string, from, to string) error
+	RemoveRedirect(nics []string, from, to string) error
+	// Removes every rule spirit-box created, including those of earlier runs.
+	Sweep() error
+}
+
+// Chooses a firewall by name: iptables, nftables, none, or auto to pick the first
+// of iptables and nftables that is installed.
+func SetFirewall(name string) error {
+	switch name {
+	case "iptables":
+		FIREWALL = Iptables{}
+	case "nftables":
+		FIREWALL = Nftables{}
+	case "none":
+		FIREWALL = NoFirewall{}
+	case "", "auto":
+		FIREWALL = detectFirewall()
+	default:
+		return fmt.Errorf("Unknown firewall %q, expected iptables, nftables, none or auto.", name)
+	}
+	log.Printf("Using firewall %s.", FIREWALL.Name())
+	return nil
+}
+
+func detectFirewall() Firewall {
+	if _, err := exec.LookPath("iptables"); err == nil {
+		return Iptables{}
+	}
+	if _, err := exec.LookPath("nft"); err == nil {
+		return Nftables{}
+	}
+	log.Print("Neither iptables nor nft found, the host port won't be redirected.")
+	return NoFirewall{}
+}
+
+// REDIRECT rules in iptables' nat table.
+type Iptables struct{}
+
+func (Iptables) Name() string {
+	return "iptables"
+}
+
+func (Iptables) AddRedirect(nics []string, from, to string) error {
+	return SetRules("-A", nics, from, to)
+}
+
+func (Iptables) RemoveRedirect(nics []string, from, to string) error {
+	return SetRules("-D", nics, from, to)
+}
+
+func (Iptables) Sweep() error {
+	return sweepIptables()
+}
+
+// Name of the nftables table spirit-box keeps its rules in. `nft delete table inet spirit_box` removes all of them.
+const NFT_TABLE = "spirit_box"
+
+// Redirect rules in a dedicated nftables table of the inet family, which covers IPv4 and IPv6.
+type Nftables struct{}
+
+func (Nftables) Name() string {
+	return "nftables"
+}
+
+func (Nftables) AddRedirect(nics []string, from, to string) error {
+	script := fmt.Sprintf(`table inet %s {
+	chain prerouting {
+		type nat hook prerouting priority -100;
+	}
+	chain output {
+		type nat hook output priority -100;
+	}
+}
+`, NFT_TABLE)
+	for _, rule := range nftRedirectRules(nics, from, to) {
+		if handles, err := nftFindRule(rule[0], rule[1]); err != nil || len(handles) == 0 {
+			script += fmt.Sprintf("add rule inet %s %s %s\n", NFT_TABLE, rule[0], rule[1])
+		}
+	}
+
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error setting nftables rule: %w: %s", err, string(out))
+	}
+	return nil
+}
+
+func (Nftables) RemoveRedirect(nics []string, from, to string) error {
+	for _, rule := range nftRedirectRules(nics, from, to) {
+		if err := nftDeleteRule(rule[0], rule[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// All of spirit-box's rules are in its own table.
+func (Nftables) Sweep() error {
+	if !nftTableExists() {
+		return nil
+	}
+	out, err := exec.Command("nft", "delete", "table", "inet", NFT_TABLE).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error deleting nftables table %s: %w: %s", NFT_TABLE, err, string(out))
+	}
+	return nil
+}
+
+// Chain and rule for each of the redirect's rules.
+func nftRedirectRules(nics []string, from, to string) [][2]string {
+	redirect := fmt.Sprintf("tcp dport %s redirect to :%s comment %q", from, to, RULE_TAG)
+	rules := make([][2]string, 0, len(nics)+2)
+	for _, nic := range nics {
+		rules = append(rules, [2]string{"prerouting", fmt.Sprintf("iifname %q %s", nic, redirect)})
+	}
+	if nics == nil { // all interfaces
+		rules = append(rules, [2]string{"prerouting", redirect})
+	}
+	return append(rules, [2]string{"output", redirect})
+}
+
+func nftTableExists() bool {
+	return exec.Command("nft", "list", "table", "inet", NFT_TABLE).Run() == nil
+}
+
+var nftHandle = regexp.MustCompile(`^\s*(.*?)\s*# handle (\d+)$`)
+
+// Returns the handles of the rules in a chain of NFT_TABLE that match rule.
+func nftFindRule(chain, rule string) ([]string, error) {
+	if !nftTableExists() {
+		return nil, nil
+	}
+	out, err := exec.Command("nft", "-a", "list", "chain", "inet", NFT_TABLE, chain).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Error listing nftables chain %s: %w", chain, err)
+	}
+
+	handles := make([]string, 0)
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		m := nftHandle.FindSubmatch(line)
+		if m != nil && string(m[1]) == rule {
+			handles = append(handles, string(m[2]))
+		}
+	}
+	return handles, nil
+}
+
+// nft can only delete rules by their handle.
+func nftDeleteRule(chain, rule string) error {
+	handles, err := nftFindRule(chain, rule)
+	if err != nil {
+		return err
+	}
+	for _, handle := range handles {
+		out, err := exec.Command("nft", "delete", "rule", "inet", NFT_TABLE, chain, "handle", handle).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Error deleting nftables rule: %w: %s", err, string(out))
+		}
+	}
+	return nil
+}
+
+// For systems where something else redirects the port, or none is needed.
+type NoFirewall struct{}
+
+func (NoFirewall) Name() string {
+	return "none"
+}
+
+func (NoFirewall) AddRedirect(nics []string, from, to string) error {
+	return nil
+}
+
+func (NoFirewall) RemoveRedirect(nics []string, from, to string) error {
+	return nil
+}
+
+func (NoFirewall) Sweep() error {
+	return nil
+}
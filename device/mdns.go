@@ -0,0 +1,671 @@
+// Announces the dashboard as an _http._tcp DNS-SD service with a small mDNS responder (RFC 6762, 6763).
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Announce the dashboard over mDNS on NICS.
+var MDNS = true
+
+var mdnsGroup = net.IPv4(224, 0, 0, 251)
+
+const (
+	mdnsPort         = 5353
+	mdnsServiceType  = "_http._tcp.local."
+	mdnsServicesEnum = "_services._dns-sd._udp.local." // lists the service types of a host
+
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsTypeANY  = 255
+
+	dnsClassIN    = 1
+	dnsCacheFlush = 0x8000 // set on records only this responder answers for
+
+	// TTLs recommended by RFC 6762 section 10
+	dnsHostTTL    = 120
+	dnsServiceTTL = 4500
+	// RFC 6762 section 6.7, for queries that don't come from port 5353
+	dnsLegacyTTL = 10
+)
+
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	flush bool
+	ttl   uint32
+	data  []byte
+}
+
+type mdnsResponder struct {
+	mu         sync.Mutex
+	announceMu sync.Mutex // held while probing and announcing, so only one runs at a time
+	conns      map[string]*net.UDPConn
+	ready      bool
+	stopped    bool
+	probed     bool // the names are ours, no queries are answered before
+	hostname   string
+	label      string // first label of the hostname
+	hostN      int    // suffix of the host name after conflicts, 1 for none
+	instanceN  int    // suffix of the instance name after conflicts, 1 for none
+	host       string // <hostname>.local.
+	instance   string // <instance name>._http._tcp.local.
+	port       uint16
+
+	// another host answered for the host or instance name while probing
+	hostConflict     bool
+	instanceConflict bool
+}
+
+var responder *mdnsResponder
+
+// Starts answering queries for the dashboard on every nic in Nics() that has an IPv4 address,
+// and announces it. Nics that get an address later are picked up by the address monitor.
+func StartMDNS() error {
+	if !MDNS {
+		return nil
+	}
+	port, err := strconv.ParseUint(SERVER_PORT, 10, 16)
+	if err != nil {
+		return fmt.Errorf("Announcing the dashboard over mDNS: invalid server port %q", SERVER_PORT)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "spirit-box"
+	}
+
+	r := &mdnsResponder{
+		conns:     map[string]*net.UDPConn{},
+		hostname:  hostname,
+		label:     strings.SplitN(hostname, ".", 2)[0],
+		hostN:     1,
+		instanceN: 1,
+		port:      uint16(port),
+	}
+	r.setNames()
+	responder = r
+	r.syncConns()
+	go r.announce()
+
+	go func() {
+		changed := AddrsChanged()
+		for {
+			<-changed
+			changed = AddrsChanged()
+			if r.isStopped() {
+				return
+			}
+			r.syncConns()
+			r.announce()
+		}
+	}()
+	return nil
+}
+
+// Sends goodbye packets, so browsers drop the service right away, and closes the sockets.
+func StopMDNS() {
+	r := responder
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	for nic, conn := range r.conns {
+		if r.probed {
+			r.send(nic, conn, 0)
+		}
+		conn.Close()
+	}
+	log.Print("Stopped announcing the dashboard over mDNS.")
+}
+
+// Updates the ready TXT record and announces the change.
+func SetMDNSReady(ready bool) {
+	r := responder
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	changed := r.ready != ready
+	r.ready = ready
+	r.mu.Unlock()
+	if changed {
+		go r.announce()
+	}
+}
+
+// Sets host and instance from the hostname, with a -2, -3, ... suffix after conflicts. r.mu has to be held.
+func (r *mdnsResponder) setNames() {
+	name := func(base string, n int) string {
+		base = dnsLabel(base)
+		if n == 1 {
+			return base
+		}
+		suffix := fmt.Sprintf("-%d", n)
+		if len(base)+len(suffix) > 63 {
+			base = base[:63-len(suffix)]
+		}
+		return base + suffix
+	}
+	r.host = name(r.label, r.hostN) + ".local."
+	r.instance = name("spirit-box on "+r.label, r.instanceN) + "." + mdnsServiceType
+}
+
+func (r *mdnsResponder) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
+
+// Opens a socket on every nic that has an IPv4 address and doesn't have one yet.
+func (r *mdnsResponder) syncConns() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	for _, nic := range Nics() {
+		if _, ok := r.conns[nic]; ok {
+			continue
+		}
+		if addrs, err := GetNicAddrs(nic); err != nil || len(addrs.IPv4) == 0 {
+			continue
+		}
+		conn, err := listenMDNS(nic)
+		if err != nil {
+			log.Printf("Announcing the dashboard over mDNS on %s: %s", nic, err.Error())
+			continue
+		}
+		log.Printf("Announcing the dashboard over mDNS on %s as %q.", nic, r.instance)
+		r.conns[nic] = conn
+		go r.serve(nic, conn)
+	}
+}
+
+func listenMDNS(nic string) (*net.UDPConn, error) {
+	ifi, err := net.InterfaceByName(nic)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		s := int(fd)
+		// send on this nic only, and only receive what arrives on it
+		sockErr = unix.SetsockoptIPMreqn(s, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, &unix.IPMreqn{Ifindex: int32(ifi.Index)})
+		if sockErr == nil {
+			sockErr = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_MULTICAST_ALL, 0)
+		}
+		if sockErr == nil {
+			sockErr = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, 255)
+		}
+		// other responders and browsers on this machine, e.g. avahi, should see the announcements
+		if sockErr == nil {
+			sockErr = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, 1)
+		}
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Sends the records twice, a second apart, as RFC 6762 section 8.3 asks for.
+// The names are probed first, if that hasn't been done yet.
+func (r *mdnsResponder) announce() {
+	r.announceMu.Lock()
+	defer r.announceMu.Unlock()
+	r.mu.Lock()
+	probed := r.probed
+	r.mu.Unlock()
+	if !probed && !r.probe() {
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		r.mu.Lock()
+		if r.stopped {
+			r.mu.Unlock()
+			return
+		}
+		for nic, conn := range r.conns {
+			r.send(nic, conn, -1)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Makes sure no other host uses the names, as RFC 6762 section 8.1 describes: three probes 250ms apart,
+// and another 250ms for answers. Names another host answers for are renamed and probed again.
+// Returns false if the responder was stopped or there is no nic to probe on yet.
+func (r *mdnsResponder) probe() bool {
+	time.Sleep(time.Duration(rand.Intn(250)) * time.Millisecond)
+	for attempt := 1; ; attempt++ {
+		if attempt > 15 { // rate limit from section 8.1, for a network that answers for every name
+			time.Sleep(5 * time.Second)
+		}
+		r.mu.Lock()
+		r.hostConflict, r.instanceConflict = false, false
+		r.mu.Unlock()
+
+		for i := 0; i < 3; i++ {
+			r.mu.Lock()
+			if r.stopped || len(r.conns) == 0 {
+				r.mu.Unlock()
+				return false
+			}
+			for nic, conn := range r.conns {
+				if _, err := conn.WriteToUDP(r.probeMessage(nic), &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}); err != nil {
+					log.Printf("Sending mDNS probe on %s: %s", nic, err.Error())
+				}
+			}
+			r.mu.Unlock()
+			time.Sleep(250 * time.Millisecond)
+		}
+
+		r.mu.Lock()
+		if !r.hostConflict && !r.instanceConflict {
+			r.probed = true
+			r.mu.Unlock()
+			return true
+		}
+		if r.hostConflict {
+			r.hostN++
+		}
+		if r.instanceConflict {
+			r.instanceN++
+		}
+		r.setNames()
+		log.Printf("mDNS name conflict, probing %s and %q instead.", r.host, r.instance)
+		r.mu.Unlock()
+	}
+}
+
+// Asks for any records of the names, with the records that will be announced in the authority section. r.mu has to be held.
+func (r *mdnsResponder) probeMessage(nic string) []byte {
+	questions := []dnsQuestion{
+		{name: r.host, qtype: dnsTypeANY, class: dnsClassIN},
+		{name: r.instance, qtype: dnsTypeANY, class: dnsClassIN},
+	}
+	authorities := append(r.serviceRecords()[2:], r.addrRecords(nic, dnsTypeANY)...) // SRV and TXT
+	return buildDNSPacket(0, 0, questions, nil, authorities, nil)
+}
+
+// Notes responses for the names while probing. Names from the group can come from this host's
+// own probes and announcements, but spirit-box doesn't answer before the names are probed.
+func (r *mdnsResponder) checkConflicts(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.probed {
+		return
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, r.host) {
+			r.hostConflict = true
+		}
+		if strings.EqualFold(name, r.instance) {
+			r.instanceConflict = true
+		}
+	}
+}
+
+// Sends all records of a nic to the group, with their own TTLs if ttl is -1.
+func (r *mdnsResponder) send(nic string, conn *net.UDPConn, ttl int) {
+	records := append(r.serviceRecords(), r.addrRecords(nic, dnsTypeANY)...)
+	if ttl >= 0 {
+		for i := range records {
+			records[i].ttl = uint32(ttl)
+		}
+	}
+	msg := buildDNSMessage(0, nil, records, nil)
+	if _, err := conn.WriteToUDP(msg, &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}); err != nil {
+		log.Printf("Sending mDNS announcement on %s: %s", nic, err.Error())
+	}
+}
+
+func (r *mdnsResponder) serve(nic string, conn *net.UDPConn) {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			r.mu.Lock()
+			if !r.stopped {
+				// e.g. the nic went away. It's reopened once it has an address again.
+				log.Printf("Reading mDNS queries on %s: %s", nic, err.Error())
+				delete(r.conns, nic)
+				conn.Close()
+			}
+			r.mu.Unlock()
+			return
+		}
+
+		msg := buf[:n]
+		if len(msg) >= 12 && binary.BigEndian.Uint16(msg[2:])&0x8000 != 0 { // a response
+			if names, err := parseDNSRecordNames(msg); err == nil {
+				r.checkConflicts(names)
+			}
+			continue
+		}
+
+		id, questions, err := parseDNSQuery(msg)
+		if err != nil || len(questions) == 0 {
+			continue
+		}
+		r.mu.Lock()
+		var answers, additionals []dnsRecord
+		if r.probed {
+			answers, additionals = r.answer(nic, questions)
+		}
+		r.mu.Unlock()
+		if len(answers) == 0 {
+			continue
+		}
+
+		if src.Port != mdnsPort { // one-shot query, e.g. from dig, which expects a regular DNS response
+			for _, records := range [][]dnsRecord{answers, additionals} {
+				for i := range records {
+					records[i].ttl = dnsLegacyTTL
+					records[i].flush = false
+				}
+			}
+			conn.WriteToUDP(buildDNSMessage(id, questions, answers, additionals), src)
+			continue
+		}
+		conn.WriteToUDP(buildDNSMessage(0, nil, answers, additionals), &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort})
+	}
+}
+
+// Records answering the questions, and the records a browser needs next. r.mu has to be held.
+func (r *mdnsResponder) answer(nic string, questions []dnsQuestion) ([]dnsRecord, []dnsRecord) {
+	services := r.serviceRecords()
+	enumPTR, servicePTR, srv, txt := services[0], services[1], services[2], services[3]
+	answers := make([]dnsRecord, 0)
+	additionals := make([]dnsRecord, 0)
+
+	for _, q := range questions {
+		all := q.qtype == dnsTypeANY
+		switch strings.ToLower(q.name) {
+		case mdnsServicesEnum:
+			if all || q.qtype == dnsTypePTR {
+				answers = append(answers, enumPTR)
+			}
+		case mdnsServiceType:
+			if all || q.qtype == dnsTypePTR {
+				answers = append(answers, servicePTR)
+				additionals = append(additionals, srv, txt)
+				additionals = append(additionals, r.addrRecords(nic, dnsTypeANY)...)
+			}
+		case strings.ToLower(r.instance):
+			if all || q.qtype == dnsTypeSRV {
+				answers = append(answers, srv)
+			}
+			if all || q.qtype == dnsTypeTXT {
+				answers = append(answers, txt)
+			}
+			additionals = append(additionals, r.addrRecords(nic, dnsTypeANY)...)
+		case strings.ToLower(r.host):
+			answers = append(answers, r.addrRecords(nic, q.qtype)...)
+		}
+	}
+	return answers, additionals
+}
+
+// The DNS-SD records: the service type enumeration and the PTR, SRV and TXT records of the instance.
+func (r *mdnsResponder) serviceRecords() []dnsRecord {
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:], r.port) // priority and weight are 0
+	srv = append(srv, encodeDNSName(r.host)...)
+
+	txt := make([]byte, 0)
+	for _, s := range []string{"path=/", "hostname=" + r.hostname, "ready=" + strconv.FormatBool(r.ready)} {
+		if len(s) > 255 {
+			s = s[:255]
+		}
+		txt = append(append(txt, byte(len(s))), s...)
+	}
+
+	return []dnsRecord{
+		{name: mdnsServicesEnum, rtype: dnsTypePTR, ttl: dnsServiceTTL, data: encodeDNSName(mdnsServiceType)},
+		{name: mdnsServiceType, rtype: dnsTypePTR, ttl: dnsServiceTTL, data: encodeDNSName(r.instance)},
+		{name: r.instance, rtype: dnsTypeSRV, flush: true, ttl: dnsHostTTL, data: srv},
+		{name: r.instance, rtype: dnsTypeTXT, flush: true, ttl: dnsServiceTTL, data: txt},
+	}
+}
+
+// A and AAAA records of the host name with the nic's addresses.
+func (r *mdnsResponder) addrRecords(nic string, qtype uint16) []dnsRecord {
+	records := make([]dnsRecord, 0)
+	addrs, err := GetNicAddrs(nic)
+	if err != nil {
+		return records
+	}
+	if qtype == dnsTypeA || qtype == dnsTypeANY {
+		for _, addr := range addrs.IPv4 {
+			if ip, _, err := net.ParseCIDR(addr); err == nil {
+				records = append(records, dnsRecord{name: r.host, rtype: dnsTypeA, flush: true, ttl: dnsHostTTL, data: ip.To4()})
+			}
+		}
+	}
+	if qtype == dnsTypeAAAA || qtype == dnsTypeANY {
+		for _, addr := range addrs.IPv6 {
+			if ip, _, err := net.ParseCIDR(addr); err == nil {
+				records = append(records, dnsRecord{name: r.host, rtype: dnsTypeAAAA, flush: true, ttl: dnsHostTTL, data: ip.To16()})
+			}
+		}
+	}
+	return records
+}
+
+// Labels are at most 63 bytes and can't contain dots.
+func dnsLabel(s string) string {
+	s = strings.ReplaceAll(s, ".", "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+func encodeDNSName(name string) []byte {
+	b := make([]byte, 0, len(name)+1)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0)
+}
+
+// An authoritative response.
+func buildDNSMessage(id uint16, questions []dnsQuestion, answers, additionals []dnsRecord) []byte {
+	return buildDNSPacket(id, 0x8400, questions, answers, nil, additionals)
+}
+
+func buildDNSPacket(id, flags uint16, questions []dnsQuestion, answers, authorities, additionals []dnsRecord) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(msg[8:], uint16(len(authorities)))
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(additionals)))
+
+	for _, q := range questions {
+		msg = append(msg, encodeDNSName(q.name)...)
+		msg = appendUint16(msg, q.qtype)
+		msg = appendUint16(msg, q.class)
+	}
+	records := make([]dnsRecord, 0, len(answers)+len(authorities)+len(additionals))
+	records = append(append(append(records, answers...), authorities...), additionals...)
+	for _, rr := range records {
+		class := uint16(dnsClassIN)
+		if rr.flush {
+			class |= dnsCacheFlush
+		}
+		msg = append(msg, encodeDNSName(rr.name)...)
+		msg = appendUint16(msg, rr.rtype)
+		msg = appendUint16(msg, class)
+		msg = appendUint32(msg, rr.ttl)
+		msg = appendUint16(msg, uint16(len(rr.data)))
+		msg = append(msg, rr.data...)
+	}
+	return msg
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+var errDNSMessage = errors.New("Malformed DNS message.")
+
+// The ID and questions of a query. Responses are ignored.
+func parseDNSQuery(msg []byte) (uint16, []dnsQuestion, error) {
+	if len(msg) < 12 {
+		return 0, nil, errDNSMessage
+	}
+	id := binary.BigEndian.Uint16(msg[0:])
+	if binary.BigEndian.Uint16(msg[2:])&0x8000 != 0 {
+		return id, nil, nil
+	}
+
+	count := int(binary.BigEndian.Uint16(msg[4:]))
+	if count > (len(msg)-12)/5 { // a question takes at least 5 bytes
+		return id, nil, errDNSMessage
+	}
+	questions := make([]dnsQuestion, 0, count)
+	off := 12
+	for i := 0; i < count; i++ {
+		name, next, err := parseDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return id, nil, errDNSMessage
+		}
+		questions = append(questions, dnsQuestion{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(msg[next:]),
+			class: binary.BigEndian.Uint16(msg[next+2:]) &^ dnsCacheFlush, // the unicast response bit
+		})
+		off = next + 4
+	}
+	return id, questions, nil
+}
+
+// Names of all records in a message, in the answer, authority and additional sections.
+func parseDNSRecordNames(msg []byte) ([]string, error) {
+	if len(msg) < 12 {
+		return nil, errDNSMessage
+	}
+	off, err := skipDNSQuestions(msg)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, at := range []int{6, 8, 10} {
+		count += int(binary.BigEndian.Uint16(msg[at:]))
+	}
+	if count > (len(msg)-off)/11 { // a record takes at least 11 bytes
+		return nil, errDNSMessage
+	}
+
+	names := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		name, next, err := parseDNSName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return nil, errDNSMessage
+		}
+		off = next + 10 + int(binary.BigEndian.Uint16(msg[next+8:])) // type, class, ttl, data length, data
+		if off > len(msg) {
+			return nil, errDNSMessage
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Returns the offset after the question section.
+func skipDNSQuestions(msg []byte) (int, error) {
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		_, next, err := parseDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return 0, errDNSMessage
+		}
+		off = next + 4
+	}
+	return off, nil
+}
+
+// Reads a possibly compressed name at off. Returns it with a trailing dot, and the offset after it.
+func parseDNSName(msg []byte, off int) (string, int, error) {
+	labels := make([]string, 0)
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSMessage
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xc0 == 0xc0: // pointer to an earlier name
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errDNSMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errDNSMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package device
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDNSQueryRoundTrip(t *testing.T) {
+	long := strings.Repeat("a", 63) + ".local."
+	tests := []struct {
+		name      string
+		questions []dnsQuestion
+		want      []dnsQuestion // defaults to questions
+	}{
+		{name: "none", questions: []dnsQuestion{}},
+		{name: "A", questions: []dnsQuestion{{name: "box.local.", qtype: dnsTypeA, class: dnsClassIN}}},
+		{
+			name: "several",
+			questions: []dnsQuestion{
+				{name: mdnsServiceType, qtype: dnsTypePTR, class: dnsClassIN},
+				{name: "spirit-box on box." + mdnsServiceType, qtype: dnsTypeSRV, class: dnsClassIN},
+				{name: "box.local.", qtype: dnsTypeANY, class: dnsClassIN},
+			},
+		},
+		{name: "longest label", questions: []dnsQuestion{{name: long, qtype: dnsTypeAAAA, class: dnsClassIN}}},
+		{
+			name:      "unicast response bit",
+			questions: []dnsQuestion{{name: "box.local.", qtype: dnsTypeA, class: dnsClassIN | dnsCacheFlush}},
+			want:      []dnsQuestion{{name: "box.local.", qtype: dnsTypeA, class: dnsClassIN}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == nil {
+				want = tt.questions
+			}
+			id, questions, err := parseDNSQuery(buildDNSPacket(0x1234, 0, tt.questions, nil, nil, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != 0x1234 || !reflect.DeepEqual(questions, want) {
+				t.Errorf("parseDNSQuery() = %#x, %v, want %#x, %v", id, questions, 0x1234, want)
+			}
+		})
+	}
+}
+
+func TestDNSResponseRoundTrip(t *testing.T) {
+	questions := []dnsQuestion{{name: "box.local.", qtype: dnsTypeA, class: dnsClassIN}}
+	answers := []dnsRecord{{name: "box.local.", rtype: dnsTypeA, flush: true, ttl: dnsHostTTL, data: []byte{10, 0, 0, 1}}}
+	additionals := []dnsRecord{{name: "spirit-box on box." + mdnsServiceType, rtype: dnsTypeTXT, ttl: dnsServiceTTL, data: []byte{0}}}
+	msg := buildDNSMessage(7, questions, answers, additionals)
+
+	// responses aren't queries
+	id, qs, err := parseDNSQuery(msg)
+	if err != nil || id != 7 || qs != nil {
+		t.Errorf("parseDNSQuery(response) = %d, %v, %v, want 7 and no questions", id, qs, err)
+	}
+
+	names, err := parseDNSRecordNames(msg)
+	want := []string{"box.local.", "spirit-box on box." + mdnsServiceType}
+	if err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("parseDNSRecordNames() = %v, %v, want %v", names, err, want)
+	}
+
+	// the flush bit is part of the class
+	off := 12 + len(encodeDNSName("box.local.")) + 4 + len(encodeDNSName("box.local."))
+	if class := binary.BigEndian.Uint16(msg[off+2:]); class != dnsClassIN|dnsCacheFlush {
+		t.Errorf("class of the answer = %#x, want %#x", class, dnsClassIN|dnsCacheFlush)
+	}
+}
+
+func newTestResponder() *mdnsResponder {
+	r := &mdnsResponder{hostname: "box", label: "box", hostN: 1, instanceN: 1, port: 8080, probed: true}
+	r.setNames()
+	return r
+}
+
+func TestAnswer(t *testing.T) {
+	r := newTestResponder()
+	instance := "spirit-box on box." + mdnsServiceType
+	type rr struct {
+		name  string
+		rtype uint16
+	}
+	srv, txt, a := rr{instance, dnsTypeSRV}, rr{instance, dnsTypeTXT}, rr{"box.local.", dnsTypeA}
+
+	tests := []struct {
+		name            string
+		question        dnsQuestion
+		wantAnswers     []rr
+		wantAdditionals []rr
+	}{
+		{name: "service types", question: dnsQuestion{name: mdnsServicesEnum, qtype: dnsTypePTR},
+			wantAnswers: []rr{{mdnsServicesEnum, dnsTypePTR}}},
+		{name: "instances", question: dnsQuestion{name: mdnsServiceType, qtype: dnsTypePTR},
+			wantAnswers: []rr{{mdnsServiceType, dnsTypePTR}}, wantAdditionals: []rr{srv, txt, a}},
+		{name: "SRV", question: dnsQuestion{name: instance, qtype: dnsTypeSRV},
+			wantAnswers: []rr{srv}, wantAdditionals: []rr{a}},
+		{name: "TXT", question: dnsQuestion{name: instance, qtype: dnsTypeTXT},
+			wantAnswers: []rr{txt}, wantAdditionals: []rr{a}},
+		{name: "ANY instance", question: dnsQuestion{name: instance, qtype: dnsTypeANY},
+			wantAnswers: []rr{srv, txt}, wantAdditionals: []rr{a}},
+		{name: "A", question: dnsQuestion{name: "box.local.", qtype: dnsTypeA}, wantAnswers: []rr{a}},
+		{name: "case insensitive", question: dnsQuestion{name: "BOX.Local.", qtype: dnsTypeA}, wantAnswers: []rr{a}},
+		{name: "AAAA without IPv6", question: dnsQuestion{name: "box.local.", qtype: dnsTypeAAAA}},
+		{name: "PTR of the host", question: dnsQuestion{name: "box.local.", qtype: dnsTypePTR}},
+		{name: "other service", question: dnsQuestion{name: "_ssh._tcp.local.", qtype: dnsTypePTR}},
+		{name: "other host", question: dnsQuestion{name: "other.local.", qtype: dnsTypeA}},
+	}
+
+	names := func(records []dnsRecord) []rr {
+		ret := make([]rr, 0, len(records))
+		for _, r := range records {
+			ret = append(ret, rr{r.name, r.rtype})
+		}
+		return ret
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.question.class = dnsClassIN
+			// lo only has 127.0.0.1, its IPv6 address isn't global
+			answers, additionals := r.answer("lo", []dnsQuestion{tt.question})
+			if got := names(answers); !(len(got) == 0 && len(tt.wantAnswers) == 0) && !reflect.DeepEqual(got, tt.wantAnswers) {
+				t.Errorf("answers = %v, want %v", got, tt.wantAnswers)
+			}
+			if got := names(additionals); !(len(got) == 0 && len(tt.wantAdditionals) == 0) && !reflect.DeepEqual(got, tt.wantAdditionals) {
+				t.Errorf("additionals = %v, want %v", got, tt.wantAdditionals)
+			}
+		})
+	}
+}
+
+func TestServiceRecordData(t *testing.T) {
+	r := newTestResponder()
+	r.ready = true
+	records := r.serviceRecords()
+
+	srv := records[2].data
+	if port := binary.BigEndian.Uint16(srv[4:]); port != 8080 {
+		t.Errorf("SRV port = %d, want 8080", port)
+	}
+	if target, _, err := parseDNSName(srv, 6); err != nil || target != "box.local." {
+		t.Errorf("SRV target = %q, %v, want box.local.", target, err)
+	}
+
+	txt := make([]string, 0)
+	for data := records[3].data; len(data) > 0; data = data[1+int(data[0]):] {
+		txt = append(txt, string(data[1:1+int(data[0])]))
+	}
+	if want := []string{"path=/", "hostname=box", "ready=true"}; !reflect.DeepEqual(txt, want) {
+		t.Errorf("TXT = %v, want %v", txt, want)
+	}
+
+	a := r.addrRecords("lo", dnsTypeA)
+	if len(a) != 1 || !net.IP(a[0].data).Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("A records of lo = %v, want 127.0.0.1", a)
+	}
+}
+
+func TestParseDNSName(t *testing.T) {
+	header := make([]byte, 12)
+	msg := func(parts ...[]byte) []byte {
+		b := append([]byte{}, header...)
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	boxLocal := encodeDNSName("box.local.") // at offset 12, 11 bytes long
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		off     int
+		want    string
+		wantEnd int
+		wantErr bool
+	}{
+		{name: "plain", msg: msg(boxLocal), off: 12, want: "box.local.", wantEnd: 23},
+		{name: "root", msg: msg([]byte{0}), off: 12, want: ".", wantEnd: 13},
+		{name: "pointer", msg: msg(boxLocal, []byte{3, 'w', 'w', 'w', 0xc0, 12}), off: 23, want: "www.box.local.", wantEnd: 29},
+		{name: "pointer to a pointer", msg: msg(boxLocal, []byte{0xc0, 12, 0xc0, 23}), off: 25, want: "box.local.", wantEnd: 27},
+		{name: "pointer to itself", msg: msg([]byte{0xc0, 12}), off: 12, wantErr: true},
+		{name: "pointer loop", msg: msg([]byte{1, 'a', 0xc0, 16, 1, 'b', 0xc0, 12}), off: 12, wantErr: true},
+		{name: "pointer past the end", msg: msg([]byte{0xc0, 0xff}), off: 12, wantErr: true},
+		{name: "truncated pointer", msg: msg([]byte{3, 'b', 'o', 'x', 0xc0}), off: 12, wantErr: true},
+		{name: "label past the end", msg: msg([]byte{10, 'b', 'o', 'x'}), off: 12, wantErr: true},
+		{name: "no terminator", msg: msg([]byte{3, 'b', 'o', 'x'}), off: 12, wantErr: true},
+		{name: "offset past the end", msg: msg(boxLocal), off: 40, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, end, err := parseDNSName(tt.msg, tt.off)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDNSName() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (name != tt.want || end != tt.wantEnd) {
+				t.Errorf("parseDNSName() = %q, %d, want %q, %d", name, end, tt.want, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestParseDNSQueryMalformed(t *testing.T) {
+	query := buildDNSPacket(1, 0, []dnsQuestion{{name: "box.local.", qtype: dnsTypeA, class: dnsClassIN}}, nil, nil, nil)
+	withCount := func(count uint16) []byte {
+		b := append([]byte{}, query...)
+		binary.BigEndian.PutUint16(b[4:], count)
+		return b
+	}
+	loop := append(append([]byte{}, query[:12]...), 0xc0, 12, 0, 1, 0, 1)
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{name: "short header", msg: query[:11]},
+		{name: "huge question count", msg: withCount(0xffff)},
+		{name: "more questions than sent", msg: withCount(2)},
+		{name: "no type and class", msg: query[:len(query)-2]},
+		{name: "compression loop", msg: loop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, questions, err := parseDNSQuery(tt.msg); err == nil {
+				t.Errorf("parseDNSQuery() = %v, want an error", questions)
+			}
+		})
+	}
+
+	response := buildDNSMessage(0, nil, []dnsRecord{{name: "box.local.", rtype: dnsTypeA, ttl: 1, data: []byte{10, 0, 0, 1}}}, nil)
+	binary.BigEndian.PutUint16(response[6:], 0xffff)
+	if names, err := parseDNSRecordNames(response); err == nil {
+		t.Errorf("parseDNSRecordNames() with a huge record count = %v, want an error", names)
+	}
+}
+
+func TestNameConflicts(t *testing.T) {
+	r := newTestResponder()
+	r.probed = false
+	r.checkConflicts([]string{"BOX.local.", "other.local."})
+	if !r.hostConflict || r.instanceConflict {
+		t.Errorf("conflicts = %v, %v, want only the host name", r.hostConflict, r.instanceConflict)
+	}
+
+	r.hostN, r.instanceN = 2, 3
+	r.setNames()
+	if r.host != "box-2.local." || r.instance != "spirit-box on box-3."+mdnsServiceType {
+		t.Errorf("names after conflicts = %q, %q", r.host, r.instance)
+	}
+
+	r.label = strings.Repeat("b", 70)
+	r.setNames()
+	if want := strings.Repeat("b", 61) + "-2.local."; r.host != want {
+		t.Errorf("long name after a conflict = %q, want %q", r.host, want)
+	}
+
+	r.probed = true
+	r.hostConflict = false
+	r.checkConflicts([]string{r.host})
+	if r.hostConflict {
+		t.Errorf("conflict noted after probing")
+	}
+}
@@ -0,0 +1,218 @@
+// Watches the nics' addresses and links over netlink, so the UIs are updated as soon as they change.
+package device
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"spirit-box/logging"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// How long a nic without an interface or address is shown as waiting for one after
+// StartMonitor. Once it has passed the nic is shown and logged as missing its address.
+var ADDR_WAIT_TIMEOUT = 30 * time.Second
+
+// Used when the netlink socket can't be opened.
+var ADDR_POLL_INTERVAL = 3 * time.Second
+
+// What the monitor last saw of a nic.
+type nicState struct {
+	addrs   NicAddrs
+	missing bool   // the interface doesn't exist
+	link    string // operstate, e.g. up, down or unknown
+}
+
+var monitorMu sync.Mutex
+var monitorStart time.Time
+var nicStates = map[string]nicState{}
+var addrsChanged = make(chan struct{})
+
+type AddressChange struct {
+	Nic     string   `json:"nic"`
+	Missing bool     `json:"missing"`
+	Link    string   `json:"link"`
+	Addrs   []string `json:"addrs"`
+	Timeout bool     `json:"timeout"` // logged when ADDR_WAIT_TIMEOUT passed without an address
+}
+
+func (a *AddressChange) LogLine() string {
+	switch {
+	case a.Timeout && a.Missing:
+		return fmt.Sprintf("Interface %s not found after %s.", a.Nic, ADDR_WAIT_TIMEOUT)
+	case a.Timeout:
+		return fmt.Sprintf("No address on %s after %s.", a.Nic, ADDR_WAIT_TIMEOUT)
+	case a.Missing:
+		return fmt.Sprintf("Interface %s not found.", a.Nic)
+	case len(a.Addrs) == 0:
+		return fmt.Sprintf("%s has no address, link %s.", a.Nic, a.Link)
+	}
+	return fmt.Sprintf("%s addresses: %s, link %s.", a.Nic, strings.Join(a.Addrs, ", "), a.Link)
+}
+
+func (a *AddressChange) GetObjType() string {
+	return "Address change"
+}
+
+// A channel that is closed the next time an address or link of a nic changes.
+func AddrsChanged() <-chan struct{} {
+	monitorMu.Lock()
+	defer monitorMu.Unlock()
+	return addrsChanged
+}
+
+// Whether nics without an address are still waited for.
+func AddrWaiting() bool {
+	monitorMu.Lock()
+	defer monitorMu.Unlock()
+	return !monitorStart.IsZero() && time.Since(monitorStart) < ADDR_WAIT_TIMEOUT
+}
+
+// Starts watching the addresses and links of Nics(). Never blocks: nics without an address
+// are shown as waiting for one, and if netlink isn't available the addresses are polled.
+func StartMonitor() {
+	monitorMu.Lock()
+	monitorStart = time.Now()
+	monitorMu.Unlock()
+	refreshAddrs()
+
+	fd, err := netlinkSocket()
+	if err != nil {
+		log.Printf("Watching addresses over netlink: %s. Polling every %s instead.", err.Error(), ADDR_POLL_INTERVAL)
+		go pollAddrs()
+	} else {
+		go readNetlink(fd)
+	}
+
+	go func() {
+		time.Sleep(ADDR_WAIT_TIMEOUT)
+		logAddrTimeouts()
+	}()
+}
+
+func netlinkSocket() (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return -1, err
+	}
+	sa := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// The messages only tell that something changed, the addresses are reread with net.Interfaces.
+func readNetlink(fd int) {
+	defer unix.Close(fd)
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.ENOBUFS { // messages were dropped
+			refreshAddrs()
+			continue
+		}
+		if err != nil {
+			log.Printf("Reading netlink socket: %s. Polling every %s instead.", err.Error(), ADDR_POLL_INTERVAL)
+			pollAddrs()
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			log.Printf("Parsing netlink message: %s", err.Error())
+			refreshAddrs()
+			continue
+		}
+		changed := false
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case unix.RTM_NEWADDR, unix.RTM_DELADDR, unix.RTM_NEWLINK, unix.RTM_DELLINK:
+				changed = true
+			}
+		}
+		if changed {
+			refreshAddrs()
+		}
+	}
+}
+
+func pollAddrs() {
+	for {
+		time.Sleep(ADDR_POLL_INTERVAL)
+		refreshAddrs()
+	}
+}
+
+// Operational state of a link as the kernel reports it in /sys/class/net, "" if the nic doesn't exist.
+func LinkState(nic string) string {
+	return readSysNet(nic, "operstate")
+}
+
+// Rereads the nics, logs the ones that changed and wakes everyone waiting on AddrsChanged.
+func refreshAddrs() {
+	now := time.Now()
+	changes := make([]*AddressChange, 0)
+
+	monitorMu.Lock()
+	for _, nic := range Nics() {
+		addrs, err := GetNicAddrs(nic)
+		state := nicState{addrs: addrs, missing: err != nil, link: LinkState(nic)}
+		if old, ok := nicStates[nic]; ok && reflect.DeepEqual(old, state) {
+			continue
+		}
+		nicStates[nic] = state
+		changes = append(changes, &AddressChange{
+			Nic:     nic,
+			Missing: state.missing,
+			Link:    state.link,
+			Addrs:   append(addrs.IPv4, addrs.IPv6...),
+		})
+	}
+	if len(changes) > 0 {
+		close(addrsChanged)
+		addrsChanged = make(chan struct{})
+	}
+	monitorMu.Unlock()
+
+	for _, obj := range changes {
+		logAddrChange(obj, now)
+	}
+}
+
+// Logs the nics that still have no address and tells the UIs to stop showing them as waiting.
+func logAddrTimeouts() {
+	now := time.Now()
+	monitorMu.Lock()
+	for _, nic := range Nics() {
+		state := nicStates[nic]
+		if state.missing || len(state.addrs.IPv4)+len(state.addrs.IPv6) == 0 {
+			logAddrChange(&AddressChange{Nic: nic, Missing: state.missing, Link: state.link, Addrs: []string{}, Timeout: true}, now)
+		}
+	}
+	close(addrsChanged)
+	addrsChanged = make(chan struct{})
+	monitorMu.Unlock()
+}
+
+func logAddrChange(obj *AddressChange, at time.Time) {
+	log.Print(obj.LogLine())
+	go func(obj *AddressChange, at time.Time) {
+		le := logging.NewLogEvent(obj.LogLine(), obj)
+		le.StartTime = at
+		le.EndTime = at
+		logging.Logs.AddLogEvent(le)
+	}(obj, at)
+}
@@ -0,0 +1,166 @@
+// Readiness probe of the host application, checked before the host port is handed back.
+package device
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"spirit-box/logging"
+	"sync"
+	"time"
+)
+
+// "" disables the probe. "http" and "tcp" probe the temp port of every mapping on localhost,
+// otherwise a URL like http://localhost:8081/health or tcp://localhost:8081.
+var HOST_PROBE = ""
+
+// Status code the host has to answer http probes with. 0 accepts any status.
+var HOST_PROBE_STATUS = 200
+var HOST_PROBE_INTERVAL = time.Second
+var HOST_PROBE_TIMEOUT = 2 * time.Second
+
+// Shared by all http probes. Without keep-alives every probe connects anew, like a visitor would.
+var probeTransport = &http.Transport{
+	// the host usually has a self-signed certificate at boot
+	TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+	DisableKeepAlives: true,
+}
+
+type ProbeResult struct {
+	Target   string        `json:"target"`
+	Time     time.Time     `json:"time"`
+	Up       bool          `json:"up"`
+	Status   int           `json:"status,omitempty"` // http probes only
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (p *ProbeResult) LogLine() string {
+	if p.Up {
+		return fmt.Sprintf("Host probe %s succeeded.", p.Target)
+	}
+	return fmt.Sprintf("Host probe %s failed: %s", p.Target, p.Error)
+}
+
+func (p *ProbeResult) GetObjType() string {
+	return "Host probe"
+}
+
+var probeMu sync.Mutex
+var lastProbes []ProbeResult
+
+// Results of the last probe, one per target. nil if the host hasn't been probed yet.
+func LastProbes() []ProbeResult {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+	return lastProbes
+}
+
+// The probe's target URLs, with the http and tcp shorthands expanded for every mapping.
+func probeTargets() []string {
+	switch HOST_PROBE {
+	case "http", "tcp":
+		targets := make([]string, 0, len(PORT_MAPPINGS))
+		for _, m := range PORT_MAPPINGS {
+			scheme := HOST_PROBE
+			if scheme == "http" && m.IsTLS() {
+				scheme = "https"
+			}
+			targets = append(targets, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort("localhost", m.TempPort)))
+		}
+		return targets
+	}
+	return []string{HOST_PROBE}
+}
+
+// Probes every target once. The host is up if all of them are.
+func ProbeHost() bool {
+	targets := probeTargets()
+	results := make([]ProbeResult, 0, len(targets))
+	up := true
+	for i, target := range targets {
+		res := probeOnce(target, i)
+		results = append(results, res)
+		up = up && res.Up
+	}
+
+	probeMu.Lock()
+	lastProbes = results
+	probeMu.Unlock()
+	return up
+}
+
+func probeOnce(target string, i int) ProbeResult {
+	res := ProbeResult{Target: target, Time: time.Now()}
+	err := probe(target, &res)
+	res.Duration = time.Since(res.Time)
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Up = true
+	}
+
+	probeMu.Lock()
+	changed := i >= len(lastProbes) || lastProbes[i].Up != res.Up || lastProbes[i].Error != res.Error
+	probeMu.Unlock()
+
+	// only log changes, the probe runs every HOST_PROBE_INTERVAL
+	if changed {
+		log.Print(res.LogLine())
+		obj := res
+		le := logging.NewLogEvent(obj.LogLine(), &obj)
+		le.StartTime = res.Time
+		le.Duration = res.Duration
+		le.EndTime = res.Time.Add(res.Duration)
+		logging.Logs.AddLogEvent(le)
+	}
+	return res
+}
+
+func probe(target string, res *ProbeResult) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", u.Host, HOST_PROBE_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http", "https":
+		client := http.Client{
+			Timeout:   HOST_PROBE_TIMEOUT,
+			Transport: probeTransport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get(target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		res.Status = resp.StatusCode
+		if HOST_PROBE_STATUS != 0 && resp.StatusCode != HOST_PROBE_STATUS {
+			return fmt.Errorf("Status %d, expected %d.", resp.StatusCode, HOST_PROBE_STATUS)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported probe %q, expected an http, https or tcp URL.", target)
+}
+
+// Blocks until the host answers the probe. Returns right away if the probe is disabled.
+func WaitForHost() {
+	if HOST_PROBE == "" {
+		return
+	}
+	for !ProbeHost() {
+		time.Sleep(HOST_PROBE_INTERVAL)
+	}
+}
@@ -0,0 +1,77 @@
+// Reverse proxy on the host port, an alternative to redirecting it with firewall rules.
+package device
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// Listen on the host ports instead of redirecting them. The host application has to listen on the temp ports.
+var PROXY = false
+
+// Serves the dashboard on m.HostPort until the host is up, then forwards every
+// request to the host application on m.TempPort. httputil.ReverseProxy passes
+// protocol upgrades through, so WebSockets work as well.
+func NewProxyHandler(dashboard http.Handler, m PortMapping) http.Handler {
+	target := &url.URL{Scheme: "http", Host: net.JoinHostPort("localhost", m.TempPort)}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Proxying %s to %s: %s", r.URL.Path, target.Host, err.Error())
+		http.Error(w, fmt.Sprintf("Host application on port %s is not reachable.", m.TempPort), http.StatusBadGateway)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if HostIsUp() {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		dashboard.ServeHTTP(w, r)
+	})
+}
+
+// Passes every connection to m.HostPort through to the host application on m.TempPort.
+// Used for TLS ports, spirit-box can't serve the dashboard there without the host's certificate.
+func ServeTCPProxy(m PortMapping) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%s", m.HostPort))
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go pipe(conn, m.TempPort)
+	}
+}
+
+func pipe(client net.Conn, tempPort string) {
+	defer client.Close()
+	target := net.JoinHostPort("localhost", tempPort)
+	host, err := net.DialTimeout("tcp", target, 5*time.Second)
+	if err != nil {
+		log.Printf("Proxying a connection to %s: %s", target, err.Error())
+		return
+	}
+	defer host.Close()
+
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// pass the end of the stream on, the other direction may still be sending
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go copyHalf(host, client)
+	go copyHalf(client, host)
+	<-done
+	<-done
+}
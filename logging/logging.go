@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"sync"
 	"time"
@@ -28,6 +27,7 @@ type LogEvent struct {
 	StartTime time.Time     `json:"startTime"`
 	EndTime   time.Time     `json:"endTime"`
 	Duration  time.Duration `json:"duration"`
+	ClockJump time.Duration `json:"clockJump,omitempty"` // wall clock offset from the monotonic timeline, if it was stepped
 	Desc      string        `json:"description"`
 	ObjType   string        `json:"objectType"`
 	Obj       LogObject     `json:"object"`
@@ -46,6 +46,9 @@ func NewLogEvent(desc string, obj LogObject) *LogEvent {
 }
 
 func (le *LogEvent) LogLine() string {
+	if le.ClockJump != 0 {
+		return fmt.Sprintf("%s: %s (clock jump of %s detected)", FormatTimeNano(le.EndTime), le.Obj.LogLine(), le.ClockJump)
+	}
 	return fmt.Sprintf("%s: %s", FormatTimeNano(le.EndTime), le.Obj.LogLine())
 }
 
@@ -91,17 +94,17 @@ func InitLogger() {
 	Logs.AddLogEvent(startEvent)
 }
 
-func CreateLogFile() *os.File {
+func CreateLogFile() (*os.File, error) {
 	cur_time := time.Now()
 	filename := FormatTime(cur_time) + ".log"
 
 	file, err := os.OpenFile(LOG_PATH+filename, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	// remember to close this file somewhere.
 
-	return file
+	return file, nil
 }
 
 func FormatTime(cur_time time.Time) string {
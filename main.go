@@ -4,12 +4,16 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"spirit-box/config"
 	"spirit-box/device"
 	"spirit-box/logging"
@@ -17,10 +21,11 @@ import (
 	"spirit-box/services"
 	"spirit-box/tui"
 	"spirit-box/tui_lite"
+	"strconv"
+	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/coreos/go-systemd/v22/dbus"
 	"github.com/rs/cors"
 )
 
@@ -35,11 +40,13 @@ func init() {
 		debugUsage       = "Write debugging logs to a file."
 		defaultTui       = false
 		tuiUsage         = "Use fancy TUI. Not recommended for serial consoles."
+		fakeUsage        = "Path to a json script for a fake, in-memory systemd. For running without systemd."
 	)
 
 	flag.StringVar(&config.SPIRIT_PATH, "p", defaultPath, pathUsage)
 	flag.StringVar(&config.DEBUG_FILE, "d", defaultDebugFile, debugUsage)
 	flag.BoolVar(&config.TUI_FANCY, "tui_fancy", false, tuiUsage)
+	flag.StringVar(&config.FAKE_SYSTEMD, "fake_systemd", "", fakeUsage)
 }
 
 func createSystemdHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
@@ -56,15 +63,158 @@ func createScriptsHandler(sc *scripts.ScriptController) func(http.ResponseWriter
 	}
 }
 
+func createAnalysisHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(uw.Analyze())
+	}
+}
+
+func createBootPhasesHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(uw.GetBootPhases())
+	}
+}
+
+func createJournalHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unit := r.URL.Query().Get("unit")
+		if unit == "" {
+			http.Error(w, "missing unit parameter", http.StatusBadRequest)
+			return
+		}
+		journal, err := uw.RecentJournal(unit)
+		if errors.Is(err, services.ErrUnitNotWatched) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(journal)
+	}
+}
+
+func createHistoryHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unit := r.URL.Query().Get("unit")
+		if unit == "" {
+			http.Error(w, "missing unit parameter", http.StatusBadRequest)
+			return
+		}
+		history, err := uw.PropertyHistory(unit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// Takes {"unit": ..., "action": ...}. A form or a plain fetch from another site can't send a JSON body
+// without a CORS preflight, and this route doesn't answer preflights.
+func createUnitActionHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "expected a JSON body", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req struct {
+			Unit   string `json:"unit"`
+			Action string `json:"action"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := uw.RunAction(req.Unit, req.Action)
+		switch {
+		case errors.Is(err, services.ErrActionsDisabled):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, services.ErrUnitNotWatched):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrUnknownAction):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, result)
+		}
+	}
+}
+
+func createConfigErrorsHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(uw.ConfigErrors())
+	}
+}
+
+// With ?wait=<seconds> the response is held back until an address changes or the time is up.
+func addressesHandler(w http.ResponseWriter, r *http.Request) {
+	if wait, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil && wait > 0 {
+		if wait > 60 {
+			wait = 60
+		}
+		select {
+		case <-device.AddrsChanged():
+		case <-time.After(time.Duration(wait) * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(device.GetAllNicAddrs())
+}
+
+func networkHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(device.Diagnose())
+}
+
+func createConnectionHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Banner string `json:"banner"`
+		}{uw.ConnectionBanner()})
+	}
+}
+
+func createManagerHandler(uw *services.UnitWatcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(uw.GetManagerStatus())
+	}
+}
+
 func createQuitHandler(quit chan struct{}) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		quit <- struct{}{}
 	}
 }
 
-// so frontend knows if host machine's default web page is up
+// so frontend knows if host machine's default web page is up.
+// ?format=json also returns the results of the last host probe.
 func hostUpHandler(w http.ResponseWriter, r *http.Request) {
-	if device.HOST_IS_UP {
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Up     bool                 `json:"up"`
+			Probes []device.ProbeResult `json:"probes"`
+		}{device.HostIsUp(), device.LastProbes()})
+		return
+	}
+	if device.HostIsUp() {
 		fmt.Fprintf(w, "up")
 	} else {
 		fmt.Fprintf(w, "not up")
@@ -80,6 +230,17 @@ func getFileSystem() http.FileSystem {
 	return http.FS(fsys)
 }
 
+// Removes the redirect rules before exiting, so the host's web server isn't left hidden.
+func fatal(redirecting bool, err error) {
+	device.StopMDNS()
+	if redirecting {
+		if sweepErr := device.SweepPortForwarding(); sweepErr != nil {
+			log.Print(sweepErr)
+		}
+	}
+	log.Fatal(err)
+}
+
 func main() {
 	quitWeb := make(chan struct{})
 	quitTui := make(chan struct{})
@@ -95,30 +256,55 @@ func main() {
 	}
 	defer f.Close()
 
-	config.LoadConfig()
+	// nothing to clean up yet
+	if err := config.LoadConfig(); err != nil {
+		log.Fatal(err)
+	}
 	if !config.ENABLED { // exit early
 		fmt.Printf("spirit-box is disabled. Exiting now.\n")
 		return
 	}
 
-	ip := device.GetIPv4Addr(device.NIC)
-	ip = ip[:len(ip)-3]
-
-	// apply iptables rules
-	device.UnsetPortForwarding()
-	err = device.SetPortForwarding()
-	if err != nil {
-		log.Fatal(err)
+	// apply firewall rules, not needed when running against a fake systemd or as a proxy
+	redirecting := config.FAKE_SYSTEMD == "" && !device.PROXY
+	if redirecting {
+		// rules of a run that crashed would hide the host's web server
+		if err := device.SweepPortForwarding(); err != nil {
+			log.Print(err)
+		}
+		err = device.SetPortForwarding()
+		if err != nil {
+			fatal(redirecting, err)
+		}
 	}
 
-	dConn, err := dbus.New()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer dConn.Close()
+	// remove the rules when spirit-box is stopped
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
 
 	logging.InitLogger()
-	uw := services.NewWatcher(dConn)
+	// nics that have no address yet are shown as waiting for one, they don't hold up startup
+	device.StartMonitor()
+
+	var dConn services.SystemdBackend
+	if config.FAKE_SYSTEMD != "" {
+		fake, err := services.LoadFakeSystemd(config.FAKE_SYSTEMD)
+		if err != nil {
+			fatal(redirecting, err)
+		}
+		services.JOURNAL = fake.Journal
+		dConn = fake
+	} else {
+		dConn, err = services.NewDbusBackend()
+		if err != nil {
+			fatal(redirecting, err)
+		}
+	}
+	defer dConn.Close()
+	uw, err := services.NewWatcher(dConn)
+	if err != nil {
+		fatal(redirecting, err)
+	}
 	sc := scripts.NewController()
 
 	// setup endpoints for server
@@ -126,16 +312,32 @@ func main() {
 	mux.Handle("/", http.FileServer(getFileSystem()))
 	mux.HandleFunc("/systemd", createSystemdHandler(uw))
 	mux.HandleFunc("/scripts", createScriptsHandler(sc))
+	mux.HandleFunc("/analysis", createAnalysisHandler(uw))
+	mux.HandleFunc("/boot", createBootPhasesHandler(uw))
+	mux.HandleFunc("/journal", createJournalHandler(uw))
+	mux.HandleFunc("/history", createHistoryHandler(uw))
+	mux.HandleFunc("/connection", createConnectionHandler(uw))
+	mux.HandleFunc("/manager", createManagerHandler(uw))
+	mux.HandleFunc("/config/errors", createConfigErrorsHandler(uw))
 	mux.HandleFunc("/quit", createQuitHandler(quitWeb))
 	mux.HandleFunc("/host", hostUpHandler)
+	mux.HandleFunc("/addresses", addressesHandler)
+	mux.HandleFunc("/network", networkHandler)
 
 	log.Printf("Starting server on port %s.", device.SERVER_PORT)
-	handler := cors.Default().Handler(mux)
+	// unit actions are same-origin only, everything else can be read from anywhere
+	handler := http.NewServeMux()
+	handler.Handle("/", cors.Default().Handler(mux))
+	handler.HandleFunc("/systemd/action", createUnitActionHandler(uw))
 
 	fmt.Printf("\033[2J") // clear the screen
 	log.Print("Starting spirit-box...")
 	uw.InitializeStates()
-	go sc.RunPriorityGroups()
+	go func() {
+		if err := sc.RunPriorityGroups(); err != nil {
+			fatal(redirecting, err)
+		}
+	}()
 
 	go func() { // start server, reboot if reboot message is sent
 		for {
@@ -146,27 +348,52 @@ func main() {
 				s.Shutdown(context.Background())
 			}()
 			if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatal("ListenAndServe:" + err.Error())
+				fatal(redirecting, errors.New("ListenAndServe:"+err.Error()))
 			}
 		}
 	}()
 
+	if device.PROXY { // serve the dashboard on the host ports until the host is up
+		for _, m := range device.PORT_MAPPINGS {
+			go func(m device.PortMapping) {
+				if m.IsTLS() {
+					err := device.ServeTCPProxy(m)
+					fatal(redirecting, errors.New("Proxy on port "+m.HostPort+": "+err.Error()))
+				}
+				s := http.Server{Addr: fmt.Sprintf(":%s", m.HostPort), Handler: device.NewProxyHandler(handler, m)}
+				if err := s.ListenAndServe(); err != nil {
+					fatal(redirecting, errors.New("Proxy ListenAndServe:"+err.Error()))
+				}
+			}(m)
+		}
+	}
+
+	// announce the dashboard once the server is about to listen
+	if err := device.StartMDNS(); err != nil {
+		log.Print(err)
+	}
+
 	go func() {
 		time.Sleep(time.Second)
 		for {
-			allReady := uw.AllReady()
-			if !allReady {
-				continue
+			allReady := uw.AllReady() && sc.AllReady()
+			if allReady {
+				// our checks passed, hand the port back once the host application answers
+				device.WaitForHost()
 			}
-			allReady = sc.AllReady()
 
-			//res, _ := http.Get(fmt.Sprintf("http://localhost:%s", device.TEMP_PORT))
+			if allReady && device.PROXY {
+				device.SetHostIsUp()
+				device.SetMDNSReady(true)
+				break
+			}
 			if allReady {
 				err := device.UnsetPortForwarding()
 				if err != nil {
-					log.Fatal(err)
+					fatal(redirecting, err)
 				}
-				device.HOST_IS_UP = true
+				device.SetHostIsUp()
+				device.SetMDNSReady(true)
 				time.Sleep(2 * time.Second)
 				rebootServer <- struct{}{}
 				break
@@ -175,15 +402,16 @@ func main() {
 		}
 	}()
 
+	// the tui logic will "pump" the updates of the unit watcher.
+	// no need to run uw.Start
+	// created before a signal or /quit can ask it to quit
 	var p *tea.Program
+	if config.TUI_FANCY {
+		p = tui.CreateProgram(dConn, uw, sc)
+	} else {
+		p = tui_lite.CreateProgram(dConn, uw, sc)
+	}
 	go func(quit chan struct{}) {
-		// the tui logic will "pump" the updates of the unit watcher.
-		// no need to run uw.Start
-		if config.TUI_FANCY {
-			p = tui.CreateProgram(dConn, uw, sc)
-		} else {
-			p = tui_lite.CreateProgram(dConn, uw, sc)
-		}
 		if err := p.Start(); err != nil {
 			fmt.Printf("There was an error: %v\n", err)
 			os.Exit(1)
@@ -193,16 +421,30 @@ func main() {
 		log.Print("quit signal sent to channel.")
 	}(quitTui)
 
+	stopped := false // by a signal, rather than by quitting the UI
 	select {
 	case <-quitWeb:
 		p.Quit()
 	case <-quitTui:
 		break
+	case sig := <-signals:
+		log.Printf("Received %s, exiting.", sig)
+		stopped = true
+		p.Quit()
 	}
 
 	log.Print("Cleanup.")
-	device.UnsetPortForwarding() // No problems if rules were already unset.
-	fmt.Printf("\033[2J")        // clear the screen
+	analysis := uw.Analyze()
+	analysisLog := logging.NewLogEvent(analysis.LogLine(), analysis)
+	analysisLog.StartTime = analysis.SystemdStart
+	analysisLog.Duration = analysisLog.EndTime.Sub(analysisLog.StartTime)
+	logging.Logs.AddLogEvent(analysisLog)
+
+	device.StopMDNS()
+	if redirecting {
+		device.UnsetPortForwarding() // No problems if rules were already unset.
+	}
+	fmt.Printf("\033[2J") // clear the screen
 
 	// Dump log lines to stdout for dev purposes.
 	fmt.Printf("\nLog Lines (in order of insertion):\n")
@@ -210,9 +452,21 @@ func main() {
 		fmt.Println(event.LogLine())
 	}
 
-	logFile := logging.CreateLogFile()
+	// the rules are removed already
+	logFile, err := logging.CreateLogFile()
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer logFile.Close()
 
 	logging.Logs.WriteJSON(logFile)
 	fmt.Printf("\nWrote JSON log entries to %s.\n", logFile.Name())
+
+	// the host application listens on the temp ports, only spirit-box serves the host ports
+	if device.PROXY && !stopped {
+		device.SetHostIsUp()
+		fmt.Printf("\nForwarding the host ports to the host application until spirit-box is stopped.\n")
+		sig := <-signals
+		log.Printf("Received %s, exiting.", sig)
+	}
 }
@@ -0,0 +1,469 @@
+// QR codes for the terminal. Byte mode with error correction level M, versions 1 to 10,
+// which fits URLs of up to 213 bytes.
+package qr
+
+import (
+	"errors"
+	"strings"
+)
+
+// Light modules around the code. The spec asks for 4, 2 is enough for phone
+// cameras and keeps the code on small consoles.
+const QUIET_ZONE = 2
+
+var ErrTooLong = errors.New("Text is too long for a QR code.")
+
+// Error correction level M of versions 1 to 10.
+type versionInfo struct {
+	ecPerBlock int   // error correction codewords per block
+	blocks     []int // data codewords of each block
+	align      []int // alignment pattern centers
+}
+
+var versions = []versionInfo{
+	{10, []int{16}, nil},
+	{16, []int{28}, []int{6, 18}},
+	{26, []int{44}, []int{6, 22}},
+	{18, []int{32, 32}, []int{6, 26}},
+	{24, []int{43, 43}, []int{6, 30}},
+	{16, []int{27, 27, 27, 27}, []int{6, 34}},
+	{18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	{22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	{22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	{26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+type Code struct {
+	Size       int
+	modules    [][]bool // [y][x], true is dark
+	isFunction [][]bool // finder, timing, alignment, format and version modules
+}
+
+// Encodes text in the smallest version it fits.
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+	for i, v := range versions {
+		version := i + 1
+		capacity := 0
+		for _, n := range v.blocks {
+			capacity += n
+		}
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) > 8*capacity {
+			continue
+		}
+
+		c := newCode(version)
+		c.drawFunctionPatterns(version)
+		c.drawCodewords(interleave(v, encodeData(data, countBits, capacity)))
+		c.applyBestMask(version)
+		return c, nil
+	}
+	return nil, ErrTooLong
+}
+
+func newCode(version int) *Code {
+	size := 17 + 4*version
+	c := &Code{Size: size, modules: make([][]bool, size), isFunction: make([][]bool, size)}
+	for y := 0; y < size; y++ {
+		c.modules[y] = make([]bool, size)
+		c.isFunction[y] = make([]bool, size)
+	}
+	return c
+}
+
+// Whether the module at x, y is dark. Modules outside the code are light.
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y][x]
+}
+
+// Two rows of modules per line, drawn with half blocks. Light modules are drawn,
+// so the code has the right polarity on the light-on-dark consoles spirit-box runs on.
+func (c *Code) HalfBlocks() string {
+	var b strings.Builder
+	for y := -QUIET_ZONE; y < c.Size+QUIET_ZONE; y += 2 {
+		for x := -QUIET_ZONE; x < c.Size+QUIET_ZONE; x++ {
+			top, bottom := !c.Dark(x, y), !c.Dark(x, y+1)
+			if y+1 >= c.Size+QUIET_ZONE {
+				bottom = false // past the quiet zone
+			}
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// One row of modules per line, two characters per module, for terminals without Unicode.
+func (c *Code) ASCII() string {
+	var b strings.Builder
+	for y := -QUIET_ZONE; y < c.Size+QUIET_ZONE; y++ {
+		for x := -QUIET_ZONE; x < c.Size+QUIET_ZONE; x++ {
+			if c.Dark(x, y) {
+				b.WriteString("  ")
+			} else {
+				b.WriteString("##")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Byte mode segment, terminator and padding, as data codewords.
+func encodeData(data []byte, countBits, capacity int) []byte {
+	bits := make([]bool, 0, 8*capacity)
+	appendBits := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (val>>i)&1 == 1)
+		}
+	}
+	appendBits(0x4, 4) // byte mode
+	appendBits(len(data), countBits)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	for i := 0; i < 4 && len(bits) < 8*capacity; i++ { // terminator
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := 0xec; len(bits) < 8*capacity; pad ^= 0xec ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return codewords
+}
+
+// Splits the data into blocks, adds their error correction codewords and interleaves them.
+func interleave(v versionInfo, data []byte) []byte {
+	divisor := rsDivisor(v.ecPerBlock)
+	blocks := make([][]byte, len(v.blocks))
+	ecc := make([][]byte, len(v.blocks))
+	off := 0
+	for i, n := range v.blocks {
+		blocks[i] = data[off : off+n]
+		ecc[i] = rsRemainder(blocks[i], divisor)
+		off += n
+	}
+
+	result := make([]byte, 0, len(data)+len(v.blocks)*v.ecPerBlock)
+	for i := 0; i < v.blocks[len(v.blocks)-1]; i++ { // the last blocks are the longest
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, e := range ecc {
+			result = append(result, e[i])
+		}
+	}
+	return result
+}
+
+// Reed-Solomon generator polynomial of the given degree over GF(2^8), without its leading 1.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// Multiplication modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns(version int) {
+	for i := 0; i < c.Size; i++ { // timing patterns
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	for _, center := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} { // finder patterns and separators
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && y >= 0 && x < c.Size && y < c.Size {
+					dist := max(abs(dx), abs(dy))
+					c.setFunction(x, y, dist != 2 && dist != 4)
+				}
+			}
+		}
+	}
+
+	align := versions[version-1].align
+	for i, cy := range align {
+		for j, cx := range align {
+			if i == 0 && j == 0 || i == 0 && j == len(align)-1 || i == len(align)-1 && j == 0 {
+				continue // overlaps a finder pattern
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	c.drawFormatBits(0) // reserves the format modules, redrawn with the chosen mask
+	c.drawVersion(version)
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	data := mask // error correction level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true) // always dark
+}
+
+func (c *Code) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+	}
+	bits := version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 == 1
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// Places the codewords in the zigzag order, two columns at a time from the bottom right.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 { // skip the vertical timing pattern
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i/8]>>(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	}
+	return ((x+y)%2+x*y%3)%2 == 0
+}
+
+// XORs the data modules with the mask. Applying it twice undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.isFunction[y][x] && maskBit(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+func (c *Code) applyBestMask(version int) {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+}
+
+// Penalty score of the masked code, lower is easier to scan. See ISO/IEC 18004 section 7.8.3.
+func (c *Code) penalty() int {
+	result := 0
+	for _, column := range []bool{false, true} {
+		for a := 0; a < c.Size; a++ {
+			runColor, run := false, 0
+			history := make([]int, 7)
+			for b := 0; b < c.Size; b++ {
+				dark := c.modules[a][b]
+				if column {
+					dark = c.modules[b][a]
+				}
+				if dark == runColor {
+					run++
+					if run == 5 {
+						result += 3
+					} else if run > 5 {
+						result++
+					}
+					continue
+				}
+				c.addRunHistory(run, history)
+				if !runColor {
+					result += countFinderLike(history) * 40
+				}
+				runColor, run = dark, 1
+			}
+			if runColor { // the quiet zone ends the line with a light run
+				c.addRunHistory(run, history)
+				run = 0
+			}
+			c.addRunHistory(run+c.Size, history)
+			result += countFinderLike(history) * 40
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				m := c.modules[y][x]
+				if m == c.modules[y][x+1] && m == c.modules[y+1][x] && m == c.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*10
+}
+
+func (c *Code) addRunHistory(run int, history []int) {
+	if history[0] == 0 { // the quiet zone before the line
+		run += c.Size
+	}
+	copy(history[1:], history[:len(history)-1])
+	history[0] = run
+}
+
+// Dark-light-dark runs of 1:1:3:1:1 with light space of 4 on either side.
+func countFinderLike(h []int) int {
+	n := h[1]
+	core := n > 0 && h[2] == n && h[3] == n*3 && h[4] == n && h[5] == n
+	count := 0
+	if core && h[0] >= n*4 && h[6] >= n {
+		count++
+	}
+	if core && h[6] >= n*4 && h[0] >= n {
+		count++
+	}
+	return count
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
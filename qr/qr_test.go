@@ -0,0 +1,172 @@
+package qr
+
+import (
+	"strings"
+	"testing"
+)
+
+// Reference codes from rsc.io/qr/coding with the same version and mask, '#' is dark.
+var referenceCodes = []struct {
+	name string
+	text string
+	code string
+}{
+	{
+		name: "version 1",
+		text: "http://box/",
+		code: `
+#######.#.....#######
+#.....#..###..#.....#
+#.###.#...###.#.###.#
+#.###.#.#.#...#.###.#
+#.###.#.#...#.#.###.#
+#.....#.##.#..#.....#
+#######.#.#.#.#######
+........#.###........
+#...#.######.#####..#
+####...#..#####.###..
+.##.####..##..#.##.#.
+.#.#...#..#..#.......
+..###.#...#.##.###.#.
+........##..#...####.
+#######.###.######.#.
+#.....#..#####.....##
+#.###.#.#..#...###.#.
+#.###.#...#.##..#.###
+#.###.#..###...###...
+#.....#..##.#..#.#...
+#######.#.##.#..##..#
+`,
+	},
+	{
+		name: "version 5, two blocks",
+		text: "https://spirit-box.local:8443/#/units/systemd-networkd-wait-online.service",
+		code: `
+#######..###..#.##....##..#.#.#######
+#.....#........###..#...##.##.#.....#
+#.###.#.####.##.#.#..#.#...#..#.###.#
+#.###.#.##.##....#.....#.##...#.###.#
+#.###.#.#.#..##.#########...#.#.###.#
+#.....#.###.####.#..#...#.###.#.....#
+#######.#.#.#.#.#.#.#.#.#.#.#.#######
+........#######.###.###....##........
+#.#####..##.#..##.....####....#####..
+##......##.###.#.##.#..###..##.#...#.
+#...#.####..#..##.##.#...####.####.##
+#........#.#.#.#...###....##.#.###..#
+.#.#..#.##.#.#####.##...####.########
+##..##.#......#.#..#####.##..#.#.....
+#.#####.#...#####.#.###...##..####.##
+.#.#....##..#......####.#.###..##..##
+##..#.##.###.#.#..##..#.####.##.#.##.
+....##.###....###....###.#..#..#..##.
+###.#####....#......###.#..##.###..##
+...###.##.##..#.#.#.##.#....#.##....#
+.....##..##.....##.....###.####.###..
+.##.##...#..#.#.##.#.###.#..#....#...
+#.#...##..#.#.####..#....####..###.##
+###.##.##.###..#.###.#.##.##.#.##..##
+..#..##.###..##.#.....#.###.#####.###
+#.##...#..####.#..######.#..#..#..#..
+#....###..##...####.###..####....####
+#.#.....##..##.#...#.#..#..#####.....
+#.#####.#.####.####....####.#######..
+........##.#....#.##.###.#.##...##...
+#######...###.###.#.#.#..##.#.#.#.###
+#.....#.#.#.##.....###.#....#...##..#
+#.###.#.###.#..#..##..###########.###
+#.###.#.#.##.#####.###.#.#.####.#...#
+#.###.#.##...#...#..#...#.##.#.....##
+#.....#..#.#..#.#....##....####.#...#
+#######.####....##.#...#####..#.#.###
+`,
+	},
+	{
+		name: "version 8, version information and blocks of two lengths",
+		text: "http://[fd12:3456:789a:1::1f]:8080/#/units/systemd-networkd-wait-online.service/journal?since=2026-10-19T08:00:00Z&lines=200&follow=true",
+		code: `
+#######..#.#.####.#..#..#.....##...#....#.#######
+#.....#..##..#.....#..#.##.#..##..##..###.#.....#
+#.###.#.#.###....##.#.####..#.###......##.#.###.#
+#.###.#.##...#..#..#.##.#.##.##....##..#..#.###.#
+#.###.#.#.#.##.......######...#.##...#....#.###.#
+#.....#.#.####.##.##..#...##.#....##.##...#.....#
+#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######
+........#..#..#..#....#...####..#..#.#...........
+#.#####....####..#.#.######..##..#.###.##.#####..
+....#..#.........#.#.#####...##....###.#..#####..
+##...##.#.#..#....#..##.##..##.#..###.##.#.....##
+...###..###.#####.#..#.#.######.##.#...#.#..#....
+#..##.#####.#.##.##...#.###....#..#.##.###.#.###.
+..........#.#...#.###.#.##....##....##....#.#.##.
+#####.#....#.#......###...##....#####.###...##.##
+#..#.#.#..##..####.##.#...##.#..#....#..#.###...#
+#...###.#.#........###.###..#..#..###.####.#.##..
+#####..#.##..###..#..#####.###.#.#..##...####....
+.#.#######.####.##...#...#..#.##..##..##.#.###.##
+.#..#..##.#..##...#..#.#.##.#..##........#..#....
+####.##.#..#....#.###.#.#.##.....#####.###....###
+#####.....###...####....###...#..#.##....##.###..
+.##.#####.#.##.#####.######.##....#..########..##
+.####...#.#..##..#.#..#...#####.#....##.#...#....
+#...#.#.#.#.###...#.###.#.##.#.#..####..#.#.#.###
+.#.##...##..##.#..#..##...##.####....#.##...##...
+..#######.#.#....#.##.#####.....####..#.#####.###
+.###...#.....####....#......##.##..#.##..#..#..##
+#..##.######.#.#...#.#..####.##.....#..#.#.######
+#........###..#......#.#.#.##.#..#.#...#.###.##..
+###..#####.#.###..##.......###..#.#.####..##...##
+####........#####.#..###.##...###.#..#..##.#....#
+...#..##....###.##.###.#.###.###...##.##.#..###..
+#.###...##..##...##..##.#.#...#.#...##...#.#.....
+##.#.####....#.#.##.##..##.#.##...##..########..#
+#.#....#.###.#.####.###.#.###.#####.....#####....
+###.#.##.####.##..#.##...#.#.##..####.###...#.#..
+..#.#..###.#...#..##.#.##..#..#.##.#.....##....#.
+.#...##.#..##.#.##.##...#..#.#....#.####.#####.##
+.###....#.####.........#.##.#.#.#.#..#..##.......
+###...#.##...##.#...#.#####..###..###..######.#.#
+........###.##.####...#...#..##.#..##...#...#..#.
+#######..#..####.#.####.#.###.....##..#.#.#.#..##
+#.....#.#.#.#.#.#..####...#.#.###.##.####...##.#.
+#.###.#.#..#####..#...######.##.....###.########.
+#.###.#.###..#.##.###.##...##.####...#..#####.#.#
+#.###.#.##.....#.##.##..##.###.#..#..###.##..##..
+#.....#...#.####...###.....#.#.##....######.....#
+#######.####...##.####...#..#..#.#.#####.#...####
+`,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	for _, tt := range referenceCodes {
+		t.Run(tt.name, func(t *testing.T) {
+			want := strings.Split(strings.TrimSpace(tt.code), "\n")
+			c, err := Encode(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Size != len(want) {
+				t.Fatalf("Size = %d, want %d", c.Size, len(want))
+			}
+			for y, row := range want {
+				for x := range row {
+					if c.Dark(x, y) != (row[x] == '#') {
+						t.Errorf("module %d, %d is dark: %v, want %v", x, y, c.Dark(x, y), row[x] == '#')
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeLength(t *testing.T) {
+	longest := "http://x/" + strings.Repeat("a", 204)
+	c, err := Encode(longest)
+	if err != nil || c.Size != 57 {
+		t.Fatalf("Encode() of %d bytes = %v, %v, want version 10", len(longest), c, err)
+	}
+	if _, err := Encode(longest + "a"); err != ErrTooLong {
+		t.Errorf("Encode() of %d bytes error = %v, want ErrTooLong", len(longest)+1, err)
+	}
+}
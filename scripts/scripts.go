@@ -3,7 +3,6 @@ package scripts
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os/exec"
 	"spirit-box/logging"
 	"strings"
@@ -24,25 +23,25 @@ type ScriptSpec struct {
 	TotalWaitTime int      `json:"totalWaitTime"` // the maximum amount of time in ms to wait for a success
 }
 
-func (s *ScriptSpec) Run() ScriptResult {
+func (s *ScriptSpec) Run() (ScriptResult, error) {
 	cmd := exec.Command(s.Cmd, s.Args...)
 	start := time.Now()
 	bytes, err := cmd.Output()
 	elapsed := time.Since(start)
 	if err != nil {
-		log.Fatal(err)
+		return ScriptResult{}, fmt.Errorf("Running %s: %w", s.ToString(), err)
 	}
 
 	res := ScriptResult{}
 	err = json.Unmarshal(bytes, &res)
 	if err != nil {
-		log.Fatal(err)
+		return ScriptResult{}, fmt.Errorf("Reading the output of %s: %w", s.ToString(), err)
 	}
 	res.Pid = cmd.Process.Pid
 	res.StartTime = start
 	res.ElapsedTime = elapsed
 
-	return res
+	return res, nil
 }
 
 func (s *ScriptSpec) ToString() string {
@@ -115,7 +114,9 @@ type PriorityGroup struct {
 	Trackers []*ScriptTracker `json:"trackers"`
 }
 
-func (pg *PriorityGroup) RunAll() {
+// Runs the group's scripts until they succeed or time out. Returns the first
+// error from running a script, scripts that hit one aren't retried.
+func (pg *PriorityGroup) RunAll() error {
 	// Init trackers
 	now := time.Now()
 	pg.Trackers = make([]*ScriptTracker, len(pg.Specs))
@@ -127,15 +128,23 @@ func (pg *PriorityGroup) RunAll() {
 	}
 
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
 	for i, _ := range pg.Specs {
 		wg.Add(1)
 		go func(spec *ScriptSpec, tracker *ScriptTracker) {
 			timer := time.NewTimer(time.Duration(spec.TotalWaitTime) * time.Millisecond)
 			resChan := make(chan ScriptResult)
+			errChan := make(chan error)
 		RLoop:
 			for {
 				go func() {
-					resChan <- spec.Run()
+					res, err := spec.Run()
+					if err != nil {
+						errChan <- err
+						return
+					}
+					resChan <- res
 				}()
 				select {
 				case res := <-resChan:
@@ -143,6 +152,13 @@ func (pg *PriorityGroup) RunAll() {
 					if res.Success {
 						break RLoop
 					}
+				case err := <-errChan:
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					break RLoop
 				case <-timer.C: // process took too long
 					break RLoop
 				}
@@ -165,6 +181,7 @@ func (pg *PriorityGroup) RunAll() {
 		}(pg.Specs[i], pg.Trackers[i])
 	}
 	wg.Wait()
+	return firstErr
 }
 
 func (pg *PriorityGroup) AllSucceeded() bool {
@@ -223,12 +240,15 @@ type ScriptController struct {
 	NumScripts     int              `json:"-"`
 }
 
-func (sc *ScriptController) RunPriorityGroups() {
+func (sc *ScriptController) RunPriorityGroups() error {
 	for _, pg := range sc.PriorityGroups {
 		//fmt.Printf("Running scripts in priority group %d:\n", pg.num)
-		pg.RunAll()
+		if err := pg.RunAll(); err != nil {
+			return err
+		}
 		//pg.PrintAfterRun()
 	}
+	return nil
 }
 
 func (sc *ScriptController) GetLongestCmdLength() int { // for formatting in tui
@@ -0,0 +1,121 @@
+// Operator actions (start, stop, restart, reset-failed) on watched units.
+package services
+
+import (
+	"errors"
+	"fmt"
+	"spirit-box/logging"
+	"time"
+)
+
+// Permission to start, stop and restart watched units. Separate from SYSTEMD_ACCESS.
+var UNIT_ACTIONS bool
+
+// How long to wait for the job of an action to finish before reporting it as timed out.
+var ACTION_TIMEOUT = 30 * time.Second
+
+var UnitActions = []string{"start", "stop", "restart", "reset-failed"}
+
+var ErrActionsDisabled = errors.New("Unit actions are disabled in config.")
+var ErrUnitNotWatched = errors.New("Unit is not watched by spirit-box.")
+var ErrUnknownAction = errors.New("Unknown unit action.")
+
+type UnitActionLog struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Result string `json:"result"` // job result from systemd, e.g. "done" or "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+func (a *UnitActionLog) LogLine() string {
+	if a.Error != "" {
+		return fmt.Sprintf("%s %s: %s", a.Action, a.Name, a.Error)
+	}
+	return fmt.Sprintf("%s %s: %s", a.Action, a.Name, a.Result)
+}
+
+func (a *UnitActionLog) GetObjType() string {
+	return "SystemD unit action"
+}
+
+// Runs an action on a watched unit and waits for its job to finish.
+// Returns the job result, every attempt is written to the log.
+func (uw *UnitWatcher) RunAction(name, action string) (string, error) {
+	if !UNIT_ACTIONS {
+		return "", ErrActionsDisabled
+	}
+	if !knownAction(action) {
+		return "", ErrUnknownAction
+	}
+	if !uw.isWatched(name) {
+		return "", ErrUnitNotWatched
+	}
+
+	start := time.Now()
+	result, err := uw.runAction(name, action)
+
+	obj := &UnitActionLog{Name: name, Action: action, Result: result}
+	if err != nil {
+		obj.Error = err.Error()
+	}
+	le := logging.NewLogEvent(obj.LogLine(), obj)
+	le.StartTime = start
+	le.Duration = le.EndTime.Sub(start)
+	logging.Logs.AddLogEvent(le)
+
+	return result, err
+}
+
+func (uw *UnitWatcher) runAction(name, action string) (string, error) {
+	ch := make(chan string, 1)
+	var err error
+	switch action {
+	case "start":
+		_, err = uw.DConn.StartUnit(name, "replace", ch)
+	case "stop":
+		_, err = uw.DConn.StopUnit(name, "replace", ch)
+	case "restart":
+		_, err = uw.DConn.RestartUnit(name, "replace", ch)
+	case "reset-failed":
+		err = uw.DConn.ResetFailedUnit(name)
+		if err != nil {
+			return "", err
+		}
+		return "done", nil
+	default:
+		return "", ErrUnknownAction
+	}
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case result := <-ch:
+		if result != "done" {
+			return result, fmt.Errorf("Job for %s finished with result %s.", name, result)
+		}
+		return result, nil
+	case <-time.After(ACTION_TIMEOUT):
+		return "timeout", fmt.Errorf("Job for %s did not finish within %s.", name, ACTION_TIMEOUT)
+	}
+}
+
+func knownAction(action string) bool {
+	for _, a := range UnitActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (uw *UnitWatcher) isWatched(name string) bool {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	for _, u := range uw.Units {
+		if u.Name == name {
+			return true
+		}
+	}
+	return false
+}
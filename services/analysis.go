@@ -0,0 +1,210 @@
+// Boot time analysis for watched units, similar to systemd-analyze blame and critical-chain.
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxChainDepth = 64 // guard against pathological dependency graphs
+
+// Activation timing for a single unit.
+type UnitTiming struct {
+	Name       string        `json:"name"`
+	Activating time.Time     `json:"activating"` // InactiveExitTimestampMonotonic
+	Active     time.Time     `json:"active"`     // ActiveEnterTimestampMonotonic
+	Offset     time.Duration `json:"offset"`     // time from systemd start until the unit became active
+	Duration   time.Duration `json:"duration"`   // time spent activating
+}
+
+func (t *UnitTiming) activated() bool {
+	return !t.Active.IsZero()
+}
+
+// Critical chain leading up to a watched unit. Links are ordered from the
+// watched unit down to the first unit in the chain.
+type CriticalChain struct {
+	Unit  string       `json:"unit"`
+	Links []UnitTiming `json:"links"`
+}
+
+type BootAnalysis struct {
+	SystemdStart   time.Time       `json:"systemdStart"`
+	Blame          []UnitTiming    `json:"blame"` // watched units, slowest first
+	CriticalChains []CriticalChain `json:"criticalChains"`
+}
+
+// Gathers activation timings for the watched units and follows their After=
+// dependencies to build a critical chain for each of them.
+// Units are queried from systemd as needed, units that have activated only once.
+func (uw *UnitWatcher) Analyze() *BootAnalysis {
+	a := &unitAnalyzer{
+		uw:      uw,
+		timings: make(map[string]*UnitTiming),
+		after:   make(map[string][]string),
+	}
+
+	uw.mu.Lock()
+	names := make([]string, len(uw.Units))
+	for i, u := range uw.Units {
+		names[i] = u.Name
+	}
+	for name, c := range uw.analyzed {
+		t := c.timing
+		a.timings[name] = &t
+		a.after[name] = c.after
+	}
+	uw.mu.Unlock()
+
+	analysis := &BootAnalysis{
+		SystemdStart:   SYSTEMD_START_TIME,
+		Blame:          make([]UnitTiming, 0, len(names)),
+		CriticalChains: make([]CriticalChain, 0, len(names)),
+	}
+
+	for _, name := range names {
+		t := a.timing(name)
+		if t.activated() {
+			analysis.Blame = append(analysis.Blame, *t)
+		}
+		analysis.CriticalChains = append(analysis.CriticalChains, CriticalChain{
+			Unit:  name,
+			Links: a.chain(name),
+		})
+	}
+
+	sort.SliceStable(analysis.Blame, func(i, j int) bool {
+		return analysis.Blame[i].Duration > analysis.Blame[j].Duration
+	})
+
+	uw.mu.Lock()
+	if uw.analyzed == nil {
+		uw.analyzed = make(map[string]analyzedUnit)
+	}
+	for name, t := range a.timings {
+		if t.activated() {
+			uw.analyzed[name] = analyzedUnit{timing: *t, after: a.after[name]}
+		}
+	}
+	uw.mu.Unlock()
+
+	return analysis
+}
+
+// Timing and After= dependencies of a unit that has activated. Later
+// restarts don't change how long the unit took during boot.
+type analyzedUnit struct {
+	timing UnitTiming
+	after  []string
+}
+
+type unitAnalyzer struct {
+	uw      *UnitWatcher
+	timings map[string]*UnitTiming
+	after   map[string][]string
+}
+
+func (a *unitAnalyzer) load(name string) {
+	t := &UnitTiming{Name: name}
+	a.timings[name] = t
+
+	properties, err := a.uw.DConn.GetUnitProperties(name)
+	if err != nil {
+		return
+	}
+
+	if val, ok := properties["InactiveExitTimestampMonotonic"].(uint64); ok && val != 0 {
+		t.Activating = monotonicToTime(val)
+	}
+	if val, ok := properties["ActiveEnterTimestampMonotonic"].(uint64); ok && val != 0 {
+		t.Active = monotonicToTime(val)
+		t.Offset = t.Active.Sub(SYSTEMD_START_TIME)
+	}
+	if !t.Activating.IsZero() && t.Active.After(t.Activating) {
+		t.Duration = t.Active.Sub(t.Activating)
+	}
+
+	if deps, ok := properties["After"].([]string); ok {
+		a.after[name] = deps
+	}
+}
+
+func (a *unitAnalyzer) timing(name string) *UnitTiming {
+	if _, ok := a.timings[name]; !ok {
+		a.load(name)
+	}
+	return a.timings[name]
+}
+
+// Follows the After= dependency that became active last, like systemd-analyze critical-chain.
+func (a *unitAnalyzer) chain(name string) []UnitTiming {
+	links := make([]UnitTiming, 0)
+	visited := make(map[string]bool)
+
+	cur := name
+	for depth := 0; depth < maxChainDepth && cur != "" && !visited[cur]; depth++ {
+		visited[cur] = true
+		t := a.timing(cur)
+		links = append(links, *t)
+
+		started := t.Activating
+		if started.IsZero() {
+			started = t.Active
+		}
+		next := ""
+		var latest time.Time
+		for _, dep := range a.after[cur] {
+			dt := a.timing(dep)
+			if !dt.activated() {
+				continue
+			}
+			if !started.IsZero() && dt.Active.After(started) {
+				continue // became active after the unit started activating, can't have delayed it
+			}
+			if dt.Active.After(latest) {
+				latest = dt.Active
+				next = dep
+			}
+		}
+		cur = next
+	}
+
+	return links
+}
+
+func (t *UnitTiming) LogLine() string {
+	if !t.activated() {
+		return fmt.Sprintf("%s (not activated)", t.Name)
+	}
+	if t.Duration > 0 {
+		return fmt.Sprintf("%s @%s +%s", t.Name, formatSeconds(t.Offset), formatSeconds(t.Duration))
+	}
+	return fmt.Sprintf("%s @%s", t.Name, formatSeconds(t.Offset))
+}
+
+func (c *CriticalChain) String() string {
+	var b strings.Builder
+	for i, link := range c.Links {
+		fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", i), link.LogLine())
+	}
+	return b.String()
+}
+
+func (ba *BootAnalysis) LogLine() string {
+	if len(ba.Blame) == 0 {
+		return "Boot analysis: no watched units were activated."
+	}
+	slowest := ba.Blame[0]
+	return fmt.Sprintf("Boot analysis: %d units activated, slowest was %s (%s).",
+		len(ba.Blame), slowest.Name, formatSeconds(slowest.Duration))
+}
+
+func (ba *BootAnalysis) GetObjType() string {
+	return "Boot analysis"
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
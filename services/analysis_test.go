@@ -0,0 +1,99 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A unit that started activating and became active at the given monotonic times, in microseconds.
+func timedUnit(name string, activating, active uint64, after ...string) FakeUnitSpec {
+	return FakeUnitSpec{Name: name, Properties: map[string]interface{}{
+		"InactiveExitTimestampMonotonic": activating,
+		"ActiveEnterTimestampMonotonic":  active,
+		"After":                          after,
+	}}
+}
+
+func chainNames(c CriticalChain) []string {
+	names := make([]string, 0, len(c.Links))
+	for _, link := range c.Links {
+		names = append(names, link.Name)
+	}
+	return names
+}
+
+func TestCriticalChain(t *testing.T) {
+	tests := []struct {
+		name string
+		deps []FakeUnitSpec // a.service activates from 1s to 3s after all of them
+		want []string
+	}{
+		{name: "no dependencies", want: []string{"a.service"}},
+		{
+			name: "latest dependency",
+			deps: []FakeUnitSpec{timedUnit("b.service", 0, 500000), timedUnit("c.service", 0, 800000)},
+			want: []string{"a.service", "c.service"},
+		},
+		{
+			name: "active after the unit started activating",
+			deps: []FakeUnitSpec{timedUnit("b.service", 0, 800000), timedUnit("c.service", 0, 2000000)},
+			want: []string{"a.service", "b.service"},
+		},
+		{
+			name: "not activated",
+			deps: []FakeUnitSpec{timedUnit("b.service", 0, 800000), timedUnit("c.service", 900000, 0)},
+			want: []string{"a.service", "b.service"},
+		},
+		{
+			name: "transitive",
+			deps: []FakeUnitSpec{timedUnit("b.service", 600000, 800000, "c.service"), timedUnit("c.service", 0, 500000)},
+			want: []string{"a.service", "b.service", "c.service"},
+		},
+		{
+			name: "cycle",
+			deps: []FakeUnitSpec{timedUnit("b.service", 0, 800000, "a.service")},
+			want: []string{"a.service", "b.service"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after := make([]string, 0, len(tt.deps))
+			for _, dep := range tt.deps {
+				after = append(after, dep.Name)
+			}
+			units := append([]FakeUnitSpec{timedUnit("a.service", 1000000, 3000000, after...)}, tt.deps...)
+			uw, _ := newTestWatcher(t, []UnitSpec{{Name: "a.service", SubStateDesired: "any"}}, units...)
+
+			analysis := uw.Analyze()
+			if len(analysis.CriticalChains) != 1 {
+				t.Fatalf("got %d critical chains, want 1", len(analysis.CriticalChains))
+			}
+			if got := chainNames(analysis.CriticalChains[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("critical chain = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeCache(t *testing.T) {
+	uw, fake := newTestWatcher(t, []UnitSpec{{Name: "a.service", SubStateDesired: "any"}},
+		timedUnit("a.service", 1000000, 3000000, "b.service", "c.service"),
+		timedUnit("b.service", 0, 500000),
+		timedUnit("c.service", 0, 0))
+	uw.Analyze()
+
+	// activated units are not read again, the others are
+	fake.mu.Lock()
+	fake.units["b.service"]["ActiveEnterTimestampMonotonic"] = uint64(600000)
+	fake.units["c.service"]["ActiveEnterTimestampMonotonic"] = uint64(800000)
+	fake.mu.Unlock()
+
+	analysis := uw.Analyze()
+	if got, want := chainNames(analysis.CriticalChains[0]), []string{"a.service", "c.service"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("critical chain = %v, want %v", got, want)
+	}
+	if got := uw.analyzed["b.service"].timing.Active; !got.Equal(monotonicToTime(500000)) {
+		t.Errorf("b.service active at %s, want the first reading", got)
+	}
+}
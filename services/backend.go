@@ -0,0 +1,20 @@
+package services
+
+import (
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// The calls spirit-box makes to systemd. Implemented by *dbus.Conn, DbusBackend and FakeSystemd.
+type SystemdBackend interface {
+	GetUnitProperties(unit string) (map[string]interface{}, error)
+	GetAllProperties(unit string) (map[string]interface{}, error)
+	// Returns the property in its GVariant text form, like *dbus.Conn does.
+	GetManagerProperty(prop string) (string, error)
+	StartUnit(name string, mode string, ch chan<- string) (int, error)
+	StopUnit(name string, mode string, ch chan<- string) (int, error)
+	RestartUnit(name string, mode string, ch chan<- string) (int, error)
+	ResetFailedUnit(name string) error
+	ListJobs() ([]dbus.JobStatus, error)
+	ListUnitsFiltered(states []string) ([]dbus.UnitStatus, error)
+	Close()
+}
@@ -0,0 +1,222 @@
+// Boot phase timings (firmware, loader, kernel, initrd, userspace) read from the systemd manager.
+package services
+
+import (
+	"fmt"
+	"log"
+	"spirit-box/logging"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Manager timestamps in boot order. The phase named after a timestamp lasts
+// until the next available timestamp, userspace lasts until FinishTimestamp.
+var bootPhaseOrder = []struct {
+	phase    string
+	property string
+}{
+	{"firmware", "FirmwareTimestamp"},
+	{"loader", "LoaderTimestamp"},
+	{"kernel", "KernelTimestamp"},
+	{"initrd", "InitRDTimestamp"},
+	{"userspace", "UserspaceTimestamp"},
+}
+
+type BootPhase struct {
+	Name      string        `json:"name"`
+	StartTime time.Time     `json:"startTime"`
+	EndTime   time.Time     `json:"endTime"`
+	Duration  time.Duration `json:"duration"`
+	Finished  bool          `json:"finished"`
+	logged    bool
+}
+
+func (p *BootPhase) LogLine() string {
+	if !p.Finished {
+		return fmt.Sprintf("Boot phase %s: in progress.", p.Name)
+	}
+	return fmt.Sprintf("Boot phase %s: %s", p.Name, formatSeconds(p.Duration))
+}
+
+func (p *BootPhase) GetObjType() string {
+	return "Boot phase"
+}
+
+// A manager timestamp. Firmware and loader timestamps are only meaningful as
+// monotonic values, which count backwards from the start of the kernel.
+type managerTimestamp struct {
+	realtime  uint64
+	monotonic uint64
+}
+
+func (t managerTimestamp) isSet() bool {
+	return t.realtime != 0 || t.monotonic != 0
+}
+
+// Reads the manager's boot timestamps and updates the watcher's boot phases.
+// Phases that finished since the last call are written to the log.
+func (uw *UnitWatcher) updateBootPhases() {
+	if uw.bootFinished {
+		return
+	}
+
+	// the phases start before spirit-box does, only the end of boot has to be polled
+	if uw.bootStamps == nil {
+		stamps, err := readBootStamps(uw.DConn)
+		if err != nil {
+			uw.bootPhasesError(err) // tried again on the next update
+			return
+		}
+		uw.bootStamps = stamps
+	}
+	stamps := uw.bootStamps
+	finishMono, err := getManagerUint64(uw.DConn, "FinishTimestampMonotonic")
+	if err != nil {
+		uw.bootPhasesError(err) // keep the last known phases
+		return
+	}
+	uw.bootErr = ""
+	finish := managerTimestamp{monotonic: finishMono}
+
+	at := func(name string, t managerTimestamp) time.Time {
+		if name == "FirmwareTimestamp" || name == "LoaderTimestamp" {
+			return MONOTONIC_ANCHOR.Add(-monotonicToDuration(t.monotonic))
+		}
+		return monotonicToTime(t.monotonic)
+	}
+
+	phases := make([]*BootPhase, 0, len(bootPhaseOrder))
+	for i, p := range bootPhaseOrder {
+		start := stamps[p.property]
+		if !start.isSet() && p.property != "KernelTimestamp" { // the kernel always starts at monotonic zero
+			continue
+		}
+
+		phase := &BootPhase{Name: p.phase, StartTime: at(p.property, start)}
+		for _, next := range bootPhaseOrder[i+1:] {
+			if stamps[next.property].isSet() {
+				phase.EndTime = at(next.property, stamps[next.property])
+				phase.Finished = true
+				break
+			}
+		}
+		if p.property == "UserspaceTimestamp" && finish.isSet() {
+			phase.EndTime = at("FinishTimestamp", finish)
+			phase.Finished = true
+		}
+		if phase.Finished {
+			phase.Duration = phase.EndTime.Sub(phase.StartTime)
+		}
+		phases = append(phases, phase)
+	}
+
+	// keep track of which phases have been logged across calls
+	for _, old := range uw.bootPhases {
+		for _, phase := range phases {
+			if phase.Name == old.Name {
+				phase.logged = old.logged
+			}
+		}
+	}
+	for _, phase := range phases {
+		if phase.Finished && !phase.logged {
+			le := logging.NewLogEvent(phase.LogLine(), phase)
+			le.StartTime = phase.StartTime
+			le.EndTime = phase.EndTime
+			le.Duration = phase.Duration
+			logging.Logs.AddLogEvent(le)
+			phase.logged = true
+		}
+	}
+
+	uw.bootPhases = phases
+	uw.bootFinished = finish.isSet()
+}
+
+// One line summary of the boot phases, e.g. "firmware 3.012s + kernel 1.500s + userspace (in progress)".
+func (uw *UnitWatcher) BootPhaseSummary() string {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+
+	parts := make([]string, 0, len(uw.bootPhases))
+	var total time.Duration
+	for _, phase := range uw.bootPhases {
+		if phase.Finished {
+			parts = append(parts, fmt.Sprintf("%s %s", phase.Name, formatSeconds(phase.Duration)))
+			total += phase.Duration
+		} else {
+			parts = append(parts, fmt.Sprintf("%s (in progress)", phase.Name))
+		}
+	}
+	if len(parts) == 0 {
+		return "Boot phases: unavailable"
+	}
+	if uw.bootFinished {
+		return fmt.Sprintf("Boot: %s = %s", strings.Join(parts, " + "), formatSeconds(total))
+	}
+	return fmt.Sprintf("Boot: %s", strings.Join(parts, " + "))
+}
+
+// Returns a copy of the boot phases that is safe to read without holding the lock.
+func (uw *UnitWatcher) GetBootPhases() []BootPhase {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+
+	ret := make([]BootPhase, len(uw.bootPhases))
+	for i, phase := range uw.bootPhases {
+		ret[i] = *phase
+	}
+	return ret
+}
+
+// Logs an error reading the boot timestamps when it differs from the last one. Must be called with uw.mu held.
+func (uw *UnitWatcher) bootPhasesError(err error) {
+	if err.Error() != uw.bootErr {
+		log.Print(err)
+		uw.bootErr = err.Error()
+	}
+}
+
+// Start timestamps of the boot phases, keyed by property.
+func readBootStamps(dConn SystemdBackend) (map[string]managerTimestamp, error) {
+	stamps := make(map[string]managerTimestamp)
+	for _, p := range bootPhaseOrder {
+		t, err := getManagerTimestamp(dConn, p.property)
+		if err != nil {
+			return nil, err
+		}
+		stamps[p.property] = t
+	}
+	return stamps, nil
+}
+
+// Manager properties are only available in their GVariant text form, e.g. "@t 1658251200000000".
+func getManagerTimestamp(dConn SystemdBackend, name string) (managerTimestamp, error) {
+	realtime, err := getManagerUint64(dConn, name)
+	if err != nil {
+		return managerTimestamp{}, err
+	}
+	monotonic, err := getManagerUint64(dConn, name+"Monotonic")
+	if err != nil {
+		return managerTimestamp{}, err
+	}
+	return managerTimestamp{realtime: realtime, monotonic: monotonic}, nil
+}
+
+func getManagerUint64(dConn SystemdBackend, name string) (uint64, error) {
+	val, err := dConn.GetManagerProperty(name)
+	if err != nil {
+		return 0, fmt.Errorf("Reading manager property %s: %w", name, err)
+	}
+
+	fields := strings.Fields(val)
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Reading manager property %s: %w", name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,227 @@
+// D-Bus connection to systemd that survives early boot and dropped connections.
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"spirit-box/logging"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Minimum time between connection attempts.
+var RECONNECT_INTERVAL = 2 * time.Second
+
+var ErrNotConnected = errors.New("Not connected to systemd.")
+
+// Implements SystemdBackend on top of go-systemd's D-Bus connection.
+// Connects through the system bus, or through systemd's private socket
+// (/run/systemd/private) while the system bus is not up yet. A private
+// connection is replaced by a system bus connection once it becomes available,
+// and dropped connections are re-established on the next call.
+type DbusBackend struct {
+	mu          sync.Mutex
+	conn        *dbus.Conn
+	private     bool
+	err         error // last connection error, nil while connected
+	lastAttempt time.Time
+}
+
+// Connects to systemd, retrying for a few seconds before giving up.
+func NewDbusBackend() (*DbusBackend, error) {
+	b := &DbusBackend{}
+	for i := 0; i < 10; i++ {
+		b.mu.Lock()
+		b.connect()
+		err := b.err
+		b.mu.Unlock()
+		if err == nil {
+			return b, nil
+		}
+		time.Sleep(time.Duration(500) * time.Millisecond)
+	}
+	return nil, b.err
+}
+
+// Must be called with b.mu held.
+func (b *DbusBackend) connect() {
+	b.lastAttempt = time.Now()
+
+	conn, err := dbus.NewSystemConnection()
+	if err == nil {
+		b.setConn(conn, false)
+		return
+	}
+	busErr := err
+
+	conn, err = dbus.NewSystemdConnection()
+	if err == nil {
+		b.setConn(conn, true)
+		return
+	}
+
+	b.err = fmt.Errorf("Connecting to systemd: system bus: %s, private socket: %s", busErr.Error(), err.Error())
+	log.Print(b.err)
+}
+
+// Must be called with b.mu held.
+func (b *DbusBackend) setConn(conn *dbus.Conn, private bool) {
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.conn, b.private, b.err = conn, private, nil
+
+	msg := "Connected to systemd through the system bus."
+	if private {
+		msg = "Connected to systemd through its private socket."
+	}
+	logMessage(msg)
+}
+
+// Returns the current connection, connecting or switching to the system bus if it's time to.
+func (b *DbusBackend) get() (*dbus.Conn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Since(b.lastAttempt) >= RECONNECT_INTERVAL {
+		if b.conn == nil {
+			b.connect()
+		} else if b.private {
+			b.lastAttempt = time.Now()
+			if conn, err := dbus.NewSystemConnection(); err == nil {
+				b.setConn(conn, false)
+			}
+		}
+	}
+
+	if b.conn == nil {
+		if b.err == nil {
+			return nil, ErrNotConnected
+		}
+		return nil, b.err
+	}
+	return b.conn, nil
+}
+
+// Drops the connection if err means that it's broken, so the next call reconnects.
+func (b *DbusBackend) check(conn *dbus.Conn, err error) error {
+	if err == nil || !isConnectionError(err) {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == conn {
+		b.conn.Close()
+		b.conn = nil
+		b.err = fmt.Errorf("Lost connection to systemd: %w", err)
+		b.lastAttempt = time.Time{} // reconnect right away
+		logMessage(b.err.Error())
+	}
+	return err
+}
+
+// Errors sent by systemd (e.g. unknown units) mean that the connection is fine.
+func isConnectionError(err error) bool {
+	var dbusErr godbus.Error
+	var dbusErrPtr *godbus.Error
+	return !errors.As(err, &dbusErr) && !errors.As(err, &dbusErrPtr)
+}
+
+func (b *DbusBackend) GetUnitProperties(unit string) (map[string]interface{}, error) {
+	conn, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	props, err := conn.GetUnitProperties(unit)
+	return props, b.check(conn, err)
+}
+
+func (b *DbusBackend) GetAllProperties(unit string) (map[string]interface{}, error) {
+	conn, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	props, err := conn.GetAllProperties(unit)
+	return props, b.check(conn, err)
+}
+
+func (b *DbusBackend) GetManagerProperty(prop string) (string, error) {
+	conn, err := b.get()
+	if err != nil {
+		return "", err
+	}
+	val, err := conn.GetManagerProperty(prop)
+	return val, b.check(conn, err)
+}
+
+func (b *DbusBackend) StartUnit(name string, mode string, ch chan<- string) (int, error) {
+	conn, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	id, err := conn.StartUnit(name, mode, ch)
+	return id, b.check(conn, err)
+}
+
+func (b *DbusBackend) StopUnit(name string, mode string, ch chan<- string) (int, error) {
+	conn, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	id, err := conn.StopUnit(name, mode, ch)
+	return id, b.check(conn, err)
+}
+
+func (b *DbusBackend) RestartUnit(name string, mode string, ch chan<- string) (int, error) {
+	conn, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	id, err := conn.RestartUnit(name, mode, ch)
+	return id, b.check(conn, err)
+}
+
+func (b *DbusBackend) ResetFailedUnit(name string) error {
+	conn, err := b.get()
+	if err != nil {
+		return err
+	}
+	return b.check(conn, conn.ResetFailedUnit(name))
+}
+
+func (b *DbusBackend) ListJobs() ([]dbus.JobStatus, error) {
+	conn, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := conn.ListJobs()
+	return jobs, b.check(conn, err)
+}
+
+func (b *DbusBackend) ListUnitsFiltered(states []string) ([]dbus.UnitStatus, error) {
+	conn, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	units, err := conn.ListUnitsFiltered(states)
+	return units, b.check(conn, err)
+}
+
+func (b *DbusBackend) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+func logMessage(msg string) {
+	le := logging.NewLogEvent(msg, &logging.MessageLog{Message: msg, Name: "spirit-box"})
+	logging.Logs.AddLogEvent(le)
+}
@@ -0,0 +1,114 @@
+// Details that only apply to some unit types, read from the type's own D-Bus interface.
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Only the fields for the unit's type are set.
+type UnitDetails struct {
+	NextElapse   *time.Time `json:"nextElapse,omitempty"`  // timers
+	LastTrigger  *time.Time `json:"lastTrigger,omitempty"` // timers
+	Listen       []string   `json:"listen,omitempty"`      // sockets, e.g. "Stream 0.0.0.0:22"
+	NAccepted    *uint32    `json:"nAccepted,omitempty"`   // sockets
+	NConnections *uint32    `json:"nConnections,omitempty"`
+	What         string     `json:"what,omitempty"`  // mounts
+	Where        string     `json:"where,omitempty"` // mounts
+	SysFSPath    string     `json:"sysFSPath,omitempty"`
+}
+
+// Returns nil for unit types without details.
+func readDetails(name string, properties map[string]interface{}) *UnitDetails {
+	d := &UnitDetails{}
+	switch filepath.Ext(name) {
+	case ".timer":
+		d.NextElapse = detailTime(properties, "NextElapseUSecRealtime", "NextElapseUSecMonotonic")
+		d.LastTrigger = detailTime(properties, "LastTriggerUSec", "LastTriggerUSecMonotonic")
+	case ".socket":
+		if PropertyAllowed("Listen") {
+			listen, _ := properties["Listen"].([][]interface{})
+			for _, l := range listen {
+				if len(l) == 2 {
+					d.Listen = append(d.Listen, fmt.Sprintf("%v %v", l[0], l[1]))
+				}
+			}
+		}
+		d.NAccepted = detailUint32(properties, "NAccepted")
+		d.NConnections = detailUint32(properties, "NConnections")
+	case ".mount":
+		d.What = detailString(properties, "What")
+		d.Where = detailString(properties, "Where")
+	case ".device":
+		d.SysFSPath = detailString(properties, "SysFSPath")
+	default:
+		return nil
+	}
+	return d
+}
+
+// Prefers the wall clock value, timers on the monotonic clock only have the monotonic one. nil if unset.
+func detailTime(properties map[string]interface{}, realtime, monotonic string) *time.Time {
+	if val, ok := properties[realtime].(uint64); ok && val != 0 && PropertyAllowed(realtime) {
+		t := realtimeToTime(val)
+		return &t
+	}
+	if val, ok := properties[monotonic].(uint64); ok && val != 0 && PropertyAllowed(monotonic) {
+		t := monotonicToTime(val)
+		return &t
+	}
+	return nil
+}
+
+func detailUint32(properties map[string]interface{}, key string) *uint32 {
+	if val, ok := properties[key].(uint32); ok && PropertyAllowed(key) {
+		return &val
+	}
+	return nil
+}
+
+func detailString(properties map[string]interface{}, key string) string {
+	if val, ok := properties[key].(string); ok && PropertyAllowed(key) {
+		return val
+	}
+	return ""
+}
+
+// One line summary for the TUIs, "" if there are no details.
+func (d *UnitDetails) Summary() string {
+	if d == nil {
+		return ""
+	}
+	parts := make([]string, 0)
+	if d.NextElapse != nil {
+		parts = append(parts, "next "+formatDetailTime(*d.NextElapse))
+	}
+	if d.LastTrigger != nil {
+		parts = append(parts, "last "+formatDetailTime(*d.LastTrigger))
+	}
+	if len(d.Listen) > 0 {
+		parts = append(parts, "listening on "+strings.Join(d.Listen, ", "))
+	}
+	if d.NAccepted != nil {
+		parts = append(parts, fmt.Sprintf("%d accepted", *d.NAccepted))
+	}
+	if d.NConnections != nil {
+		parts = append(parts, fmt.Sprintf("%d connected", *d.NConnections))
+	}
+	if d.What != "" || d.Where != "" {
+		parts = append(parts, fmt.Sprintf("%s on %s", d.What, d.Where))
+	}
+	if d.SysFSPath != "" {
+		parts = append(parts, d.SysFSPath)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatDetailTime(t time.Time) string {
+	if time.Since(t) < 24*time.Hour && time.Until(t) < 24*time.Hour {
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02 15:04")
+}
@@ -0,0 +1,429 @@
+// In-memory systemd backend, for running spirit-box on machines without systemd.
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// Loaded from a json file, see LoadFakeSystemd.
+type FakeUnitSpec struct {
+	Name        string                 `json:"name"`
+	LoadState   string                 `json:"loadState"`
+	ActiveState string                 `json:"activeState"`
+	SubState    string                 `json:"subState"`
+	Properties  map[string]interface{} `json:"properties"` // any additional unit properties
+}
+
+// A scripted state change of a fake unit.
+type FakeTransition struct {
+	After       int    `json:"after"` // time in ms after the fake was created
+	Unit        string `json:"unit"`
+	ActiveState string `json:"activeState"`
+	SubState    string `json:"subState"`
+	Restart     bool   `json:"restart"`    // counts as an automatic restart (NRestarts)
+	StatusText  string `json:"statusText"` // new StatusText, if set
+	Message     string `json:"message"`    // appended to the unit's journal, if set
+}
+
+type FakeScript struct {
+	Units       []FakeUnitSpec   `json:"units"`
+	Transitions []FakeTransition `json:"transitions"`
+}
+
+// Implements SystemdBackend. Units change state according to scripted
+// transitions, which are applied lazily whenever the fake is queried.
+type FakeSystemd struct {
+	Journal     *FakeJournal
+	mu          sync.Mutex
+	created     time.Time
+	createdMono uint64 // CLOCK_MONOTONIC at created, in microseconds
+	units       map[string]map[string]interface{}
+	transitions []FakeTransition
+	applied     int
+	manager     map[string]uint64
+	jobID       int
+}
+
+func NewFakeSystemd() *FakeSystemd {
+	// if the clock can't be read, NewWatcher reports it
+	now, _ := monotonicNow()
+	f := &FakeSystemd{
+		Journal:     &FakeJournal{Entries: make(map[string][]JournalEntry)},
+		created:     time.Now(),
+		createdMono: now,
+		units:       make(map[string]map[string]interface{}),
+		manager: map[string]uint64{
+			"KernelTimestamp":             uint64(time.Now().UnixMicro()) - now,
+			"KernelTimestampMonotonic":    0,
+			"UserspaceTimestamp":          uint64(time.Now().UnixMicro()),
+			"UserspaceTimestampMonotonic": now,
+		},
+	}
+
+	root := f.addUnit("-.slice", "loaded")
+	f.setState(root, "active", "active")
+	return f
+}
+
+// Creates a fake from a json file, see FakeScript.
+func LoadFakeSystemd(path string) (*FakeSystemd, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Loading fake systemd script from %s: %w", path, err)
+	}
+
+	script := FakeScript{}
+	err = json.Unmarshal(bytes, &script)
+	if err != nil {
+		return nil, fmt.Errorf("Loading fake systemd script from %s: %w", path, err)
+	}
+
+	f := NewFakeSystemd()
+	for _, spec := range script.Units {
+		f.AddUnit(spec)
+	}
+	for _, t := range script.Transitions {
+		f.AddTransition(t)
+	}
+	return f, nil
+}
+
+func (f *FakeSystemd) AddUnit(spec FakeUnitSpec) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if spec.LoadState == "" {
+		spec.LoadState = "loaded"
+	}
+	if spec.ActiveState == "" {
+		spec.ActiveState = "inactive"
+	}
+	if spec.SubState == "" {
+		spec.SubState = "dead"
+	}
+
+	props := f.addUnit(spec.Name, spec.LoadState)
+	for k, v := range spec.Properties {
+		props[k] = fromJSONValue(k, v)
+	}
+	if spec.ActiveState != "inactive" {
+		f.setState(props, spec.ActiveState, spec.SubState)
+	}
+	props["SubState"] = spec.SubState
+}
+
+func (f *FakeSystemd) AddTransition(t FakeTransition) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.transitions = append(f.transitions, t)
+	sort.SliceStable(f.transitions[f.applied:], func(i, j int) bool {
+		return f.transitions[f.applied+i].After < f.transitions[f.applied+j].After
+	})
+}
+
+func (f *FakeSystemd) addUnit(name, loadState string) map[string]interface{} {
+	props := map[string]interface{}{
+		"Id":          name,
+		"Description": name,
+		"LoadState":   loadState,
+		"ActiveState": "inactive",
+		"SubState":    "dead",
+		"After":       []string{},
+		"StatusText":  "",
+	}
+	for _, key := range []string{"InactiveEnterTimestamp", "InactiveExitTimestamp", "ActiveEnterTimestamp", "ActiveExitTimestamp"} {
+		props[key] = uint64(0)
+		props[key+"Monotonic"] = uint64(0)
+	}
+	if strings.HasSuffix(name, ".service") {
+		props["NRestarts"] = uint32(0)
+	}
+	f.units[name] = props
+	return props
+}
+
+// Sets the unit's state and the timestamp systemd would set for it.
+func (f *FakeSystemd) setState(props map[string]interface{}, activeState, subState string) {
+	props["ActiveState"] = activeState
+	props["SubState"] = subState
+	if key := timestampProperty(activeState); key != "" {
+		props[key] = uint64(time.Now().UnixMicro())
+		props[key+"Monotonic"] = f.monotonicNow()
+	}
+}
+
+// CLOCK_MONOTONIC time in microseconds, derived from the time the fake was created.
+func (f *FakeSystemd) monotonicNow() uint64 {
+	return f.createdMono + uint64(time.Since(f.created)/time.Microsecond)
+}
+
+// Applies all transitions that are due. Must be called with f.mu held.
+func (f *FakeSystemd) advance() {
+	elapsed := time.Since(f.created)
+	for f.applied < len(f.transitions) {
+		t := f.transitions[f.applied]
+		if time.Duration(t.After)*time.Millisecond > elapsed {
+			break
+		}
+		f.applied++
+
+		props, ok := f.units[t.Unit]
+		if !ok {
+			props = f.addUnit(t.Unit, "loaded")
+		}
+		if t.ActiveState != "" {
+			f.setState(props, t.ActiveState, t.SubState)
+		}
+		if t.Restart {
+			if n, ok := props["NRestarts"].(uint32); ok {
+				props["NRestarts"] = n + 1
+			}
+		}
+		if t.StatusText != "" {
+			props["StatusText"] = t.StatusText
+		}
+		if t.Message != "" {
+			f.Journal.add(t.Unit, JournalEntry{
+				Time:     time.Now(),
+				Priority: 6,
+				Message:  t.Message,
+			})
+		}
+	}
+
+	if f.applied == len(f.transitions) && f.manager["FinishTimestamp"] == 0 {
+		f.manager["FinishTimestamp"] = uint64(time.Now().UnixMicro())
+		f.manager["FinishTimestampMonotonic"] = f.monotonicNow()
+	}
+}
+
+// Returns a copy of the unit's properties, or those of a not-found unit like systemd does.
+func (f *FakeSystemd) properties(unit string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	props, ok := f.units[unit]
+	if !ok {
+		props = f.addUnit(unit, "not-found")
+		delete(f.units, unit)
+	}
+
+	ret := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (f *FakeSystemd) GetUnitProperties(unit string) (map[string]interface{}, error) {
+	return f.properties(unit), nil
+}
+
+func (f *FakeSystemd) GetAllProperties(unit string) (map[string]interface{}, error) {
+	return f.properties(unit), nil
+}
+
+func (f *FakeSystemd) GetManagerProperty(prop string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	if strings.Contains(prop, "Timestamp") {
+		return fmt.Sprintf("@t %d", f.manager[prop]), nil
+	}
+	if prop == "SystemState" {
+		return strconv.Quote(f.systemState()), nil
+	}
+	return "", fmt.Errorf("Fake systemd has no manager property %s.", prop)
+}
+
+// Runs a job on a unit. Jobs finish immediately.
+func (f *FakeSystemd) job(name string, ch chan<- string, states ...[2]string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	props, ok := f.units[name]
+	if !ok || props["LoadState"] == "not-found" {
+		return 0, fmt.Errorf("Unit %s not found.", name)
+	}
+	for _, state := range states {
+		f.setState(props, state[0], state[1])
+	}
+
+	f.jobID++
+	if ch != nil {
+		go func() { ch <- "done" }()
+	}
+	return f.jobID, nil
+}
+
+func (f *FakeSystemd) StartUnit(name string, mode string, ch chan<- string) (int, error) {
+	return f.job(name, ch, [2]string{"activating", "start"}, [2]string{"active", activeSubState(name)})
+}
+
+func (f *FakeSystemd) StopUnit(name string, mode string, ch chan<- string) (int, error) {
+	return f.job(name, ch, [2]string{"deactivating", "stop"}, [2]string{"inactive", "dead"})
+}
+
+func (f *FakeSystemd) RestartUnit(name string, mode string, ch chan<- string) (int, error) {
+	return f.job(name, ch,
+		[2]string{"deactivating", "stop"}, [2]string{"inactive", "dead"},
+		[2]string{"activating", "start"}, [2]string{"active", activeSubState(name)})
+}
+
+func (f *FakeSystemd) ResetFailedUnit(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	props, ok := f.units[name]
+	if !ok {
+		return fmt.Errorf("Unit %s not loaded.", name)
+	}
+	if props["ActiveState"] == "failed" {
+		f.setState(props, "inactive", "dead")
+	}
+	return nil
+}
+
+// Units that are activating or deactivating have a running job.
+func (f *FakeSystemd) ListJobs() ([]dbus.JobStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	jobs := make([]dbus.JobStatus, 0)
+	for _, name := range f.sortedUnits() {
+		props := f.units[name]
+		jobType := ""
+		switch props["ActiveState"] {
+		case "activating":
+			jobType = "start"
+		case "deactivating":
+			jobType = "stop"
+		default:
+			continue
+		}
+		jobs = append(jobs, dbus.JobStatus{
+			Id:      uint32(len(jobs) + 1),
+			Unit:    name,
+			JobType: jobType,
+			Status:  "running",
+		})
+	}
+	return jobs, nil
+}
+
+func (f *FakeSystemd) ListUnitsFiltered(states []string) ([]dbus.UnitStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	units := make([]dbus.UnitStatus, 0)
+	for _, name := range f.sortedUnits() {
+		props := f.units[name]
+		for _, state := range states {
+			if props["LoadState"] == state || props["ActiveState"] == state || props["SubState"] == state {
+				units = append(units, dbus.UnitStatus{
+					Name:        name,
+					Description: props["Description"].(string),
+					LoadState:   props["LoadState"].(string),
+					ActiveState: props["ActiveState"].(string),
+					SubState:    props["SubState"].(string),
+				})
+				break
+			}
+		}
+	}
+	return units, nil
+}
+
+func (f *FakeSystemd) Close() {}
+
+// Must be called with f.mu held.
+func (f *FakeSystemd) sortedUnits() []string {
+	names := make([]string, 0, len(f.units))
+	for name := range f.units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// starting until all transitions have been applied, then running or degraded. Must be called with f.mu held.
+func (f *FakeSystemd) systemState() string {
+	if f.manager["FinishTimestamp"] == 0 {
+		return "starting"
+	}
+	for _, props := range f.units {
+		if props["ActiveState"] == "failed" {
+			return "degraded"
+		}
+	}
+	return "running"
+}
+
+// Substate of an active unit, depending on its type.
+func activeSubState(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".service"):
+		return "running"
+	case strings.HasSuffix(name, ".mount"):
+		return "mounted"
+	case strings.HasSuffix(name, ".socket"):
+		return "listening"
+	case strings.HasSuffix(name, ".timer"):
+		return "waiting"
+	case strings.HasSuffix(name, ".device"):
+		return "plugged"
+	}
+	return "active"
+}
+
+// Properties systemd sends as uint32 rather than uint64.
+var fakeUint32Properties = map[string]bool{"NRestarts": true, "NAccepted": true, "NConnections": true, "MainPID": true}
+
+// json numbers are unmarshalled as float64 and arrays as []interface{},
+// systemd uses uint64 and []string for most properties, and arrays of structs
+// (e.g. Listen) arrive as [][]interface{}.
+func fromJSONValue(key string, v interface{}) interface{} {
+	switch val := v.(type) {
+	case float64:
+		if fakeUint32Properties[key] {
+			return uint32(val)
+		}
+		return uint64(val)
+	case []interface{}:
+		if len(val) > 0 {
+			if _, ok := val[0].([]interface{}); ok {
+				structs := make([][]interface{}, 0, len(val))
+				for _, item := range val {
+					if fields, ok := item.([]interface{}); ok {
+						structs = append(structs, fields)
+					}
+				}
+				return structs
+			}
+		}
+		strs := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return v
+}
@@ -0,0 +1,62 @@
+// Checks that a unit is enabled, masked or static as expected.
+package services
+
+import (
+	"fmt"
+	"spirit-box/logging"
+	"time"
+)
+
+// Values of the UnitFileState property, as printed by `systemctl is-enabled`.
+var validUnitFileStates = []string{"enabled", "enabled-runtime", "linked", "linked-runtime", "alias",
+	"masked", "masked-runtime", "static", "indirect", "disabled", "generated", "transient", "bad"}
+
+// Updates u.UnitFileState and u.FileStateDrift. Drifting units are never ready.
+func (u *UnitInfo) updateFileState(properties map[string]interface{}, now time.Time) {
+	if state, ok := properties["UnitFileState"].(string); ok {
+		u.UnitFileState = state
+	}
+	if u.UnitFileStateDesired == "" {
+		return
+	}
+
+	drift := u.UnitFileState != u.UnitFileStateDesired
+	if drift != u.FileStateDrift {
+		u.FileStateDrift = drift
+		u.logFileStateDrift(now)
+	}
+}
+
+type UnitFileStateDrift struct {
+	Name                 string `json:"name"`
+	Drift                bool   `json:"drift"`
+	UnitFileState        string `json:"unitFileState"`
+	UnitFileStateDesired string `json:"unitFileStateDesired"`
+}
+
+func (u *UnitFileStateDrift) LogLine() string {
+	if u.Drift {
+		return fmt.Sprintf("%s is %s, expected %s.", u.Name, u.UnitFileState, u.UnitFileStateDesired)
+	}
+	return fmt.Sprintf("%s is %s as expected.", u.Name, u.UnitFileState)
+}
+
+func (u *UnitFileStateDrift) GetObjType() string {
+	return "SystemD unit file state drift"
+}
+
+func (u *UnitInfo) logFileStateDrift(at time.Time) {
+	obj := &UnitFileStateDrift{
+		Name:                 u.Name,
+		Drift:                u.FileStateDrift,
+		UnitFileState:        u.UnitFileState,
+		UnitFileStateDesired: u.UnitFileStateDesired,
+	}
+
+	go func(obj *UnitFileStateDrift, at time.Time) {
+		le := logging.NewLogEvent(obj.LogLine(), obj)
+		le.StartTime = at
+		le.EndTime = at
+		logging.Logs.AddLogEvent(le)
+	}(obj, at)
+}
@@ -0,0 +1,128 @@
+// Filtering of unit properties before they are shown to users.
+package services
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Glob patterns of properties that may be shown. Empty allows all properties.
+var PROPERTY_ALLOWLIST []string
+
+// Glob patterns of properties that are never shown. Takes precedence over the allowlist.
+var PROPERTY_DENYLIST = []string{
+	"Environment",
+	"EnvironmentFiles",
+	"PassEnvironment",
+	"UnsetEnvironment",
+	"SetCredential*",
+	"LoadCredential*",
+	"ImportCredential",
+}
+
+// Replace the arguments of ExecStart and friends with a placeholder, keeping the binary.
+var REDACT_EXEC_ARGS = true
+
+// Properties holding command lines. Other Exec* properties, like ExecMainPID, are numbers.
+var execProperties = map[string]bool{
+	"ExecStart":     true,
+	"ExecStartPre":  true,
+	"ExecStartPost": true,
+	"ExecReload":    true,
+	"ExecStop":      true,
+	"ExecStopPost":  true,
+}
+
+func PropertyAllowed(name string) bool {
+	for _, pattern := range PROPERTY_DENYLIST {
+		if matchProperty(pattern, name) {
+			return false
+		}
+	}
+	if len(PROPERTY_ALLOWLIST) == 0 {
+		return true
+	}
+	for _, pattern := range PROPERTY_ALLOWLIST {
+		if matchProperty(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the properties with denied properties removed and exec arguments redacted.
+func FilterProperties(properties map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(properties))
+	for k, v := range properties {
+		if !PropertyAllowed(k) {
+			continue
+		}
+		// other Exec* properties may hold command lines too, e.g. ExecCondition or ExecStartEx
+		if REDACT_EXEC_ARGS && (execProperties[k] || strings.HasPrefix(k, "Exec") && isCommandLines(v)) {
+			v = redactExecArgs(v)
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
+// Whether v is an array of structs starting with (path, argv), like ExecStart.
+func isCommandLines(v interface{}) bool {
+	cmds, ok := v.([][]interface{})
+	if !ok || len(cmds) == 0 {
+		return false
+	}
+	for _, cmd := range cmds {
+		if len(cmd) < 2 {
+			return false
+		}
+		_, isPath := cmd[0].(string)
+		_, isArgv := cmd[1].([]string)
+		if !isPath || !isArgv {
+			return false
+		}
+	}
+	return true
+}
+
+func matchProperty(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// Exec properties are arrays of (path, argv, ignore failure, timestamps..., pid, exit code, status).
+// Only the path is kept, the arguments may contain secrets.
+func redactExecArgs(v interface{}) interface{} {
+	cmds, ok := v.([][]interface{})
+	if !ok {
+		if _, isStr := v.(string); isStr {
+			return v
+		}
+		return "[redacted]"
+	}
+
+	ret := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		if len(cmd) == 0 {
+			continue
+		}
+		p, isPath := cmd[0].(string)
+		var argv []string
+		isArgv := false
+		if len(cmd) > 1 {
+			argv, isArgv = cmd[1].([]string)
+		}
+		if !isPath || !isArgv {
+			// not a command line, don't guess what it holds
+			ret = append(ret, "[redacted]")
+			continue
+		}
+		numArgs := 0
+		if len(argv) > 0 {
+			numArgs = len(argv) - 1 // argv[0] is the binary itself
+		}
+		ret = append(ret, fmt.Sprintf("%s [%d arguments redacted]", p, numArgs))
+	}
+	return ret
+}
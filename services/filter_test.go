@@ -0,0 +1,46 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterProperties(t *testing.T) {
+	execStart := [][]interface{}{{"/usr/bin/app", []string{"/usr/bin/app", "--token", "secret"}, false, uint64(0)}}
+	tests := []struct {
+		name   string
+		prop   string
+		value  interface{}
+		redact bool
+		want   interface{} // nil if the property is dropped
+	}{
+		{name: "exec start", prop: "ExecStart", value: execStart, redact: true, want: []string{"/usr/bin/app [2 arguments redacted]"}},
+		{name: "exec stop post", prop: "ExecStopPost", value: execStart, redact: true, want: []string{"/usr/bin/app [2 arguments redacted]"}},
+		{name: "not redacted", prop: "ExecStart", value: execStart, redact: false, want: execStart},
+		{name: "other command line", prop: "ExecCondition", value: execStart, redact: true, want: []string{"/usr/bin/app [2 arguments redacted]"}},
+		{name: "other struct array", prop: "Listen", value: [][]interface{}{{"Stream", "0.0.0.0:80"}}, redact: true, want: [][]interface{}{{"Stream", "0.0.0.0:80"}}},
+		{name: "paths", prop: "Paths", value: [][]interface{}{{"PathExists", "/run/ready"}}, redact: true, want: [][]interface{}{{"PathExists", "/run/ready"}}},
+		{name: "exec struct array without argv", prop: "ExecTimes", value: [][]interface{}{{"start", uint64(1)}}, redact: true, want: [][]interface{}{{"start", uint64(1)}}},
+		{name: "main pid", prop: "ExecMainPID", value: uint32(42), redact: true, want: uint32(42)},
+		{name: "main status", prop: "ExecMainStatus", value: int32(1), redact: true, want: int32(1)},
+		{name: "main start", prop: "ExecMainStartTimestamp", value: uint64(1700000000000000), redact: true, want: uint64(1700000000000000)},
+		{name: "denied", prop: "Environment", value: []string{"TOKEN=secret"}, redact: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set(t, &REDACT_EXEC_ARGS, tt.redact)
+			filtered := FilterProperties(map[string]interface{}{tt.prop: tt.value})
+			got, ok := filtered[tt.prop]
+			if tt.want == nil {
+				if ok {
+					t.Errorf("%s = %v, want it dropped", tt.prop, got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %#v, want %#v", tt.prop, got, tt.want)
+			}
+		})
+	}
+}
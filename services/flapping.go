@@ -0,0 +1,83 @@
+// Detection of units that restart or change state too often to be considered ready.
+package services
+
+import (
+	"fmt"
+	"spirit-box/logging"
+	"time"
+)
+
+// Number of state changes and restarts within FLAP_WINDOW at which a unit is
+// considered to be flapping. 0 disables flap detection.
+var FLAP_THRESHOLD = 5
+var FLAP_WINDOW = time.Minute
+
+// How long a flapping unit has to stay unchanged before it can be ready again.
+var FLAP_SETTLE_TIME = 10 * time.Second
+
+// Records state changes and restarts of a unit and updates u.Flapping.
+// events is the number of changes observed since the last update.
+func (u *UnitInfo) updateFlapping(now time.Time, events int) {
+	if FLAP_THRESHOLD <= 0 {
+		return
+	}
+
+	for i := 0; i < events; i++ {
+		u.changes = append(u.changes, now)
+	}
+	if events > 0 {
+		u.lastChange = now
+	}
+
+	// drop changes that are outside of the window
+	cutoff := now.Add(-FLAP_WINDOW)
+	i := 0
+	for i < len(u.changes) && u.changes[i].Before(cutoff) {
+		i++
+	}
+	u.changes = u.changes[i:]
+
+	if !u.Flapping && len(u.changes) >= FLAP_THRESHOLD {
+		u.Flapping = true
+		u.logFlapping(u.changes[0], now)
+	} else if u.Flapping && now.Sub(u.lastChange) >= FLAP_SETTLE_TIME {
+		u.Flapping = false
+		u.changes = nil
+		u.logFlapping(u.lastChange, now)
+	}
+}
+
+type UnitFlapChange struct {
+	Name         string `json:"name"`
+	Flapping     bool   `json:"flapping"`
+	StateChanges int    `json:"stateChanges"` // within the flap window
+	NRestarts    uint32 `json:"nRestarts"`
+}
+
+func (u *UnitFlapChange) LogLine() string {
+	if u.Flapping {
+		return fmt.Sprintf("%s is flapping: %d state changes, %d restarts.", u.Name, u.StateChanges, u.NRestarts)
+	}
+	return fmt.Sprintf("%s has stopped flapping.", u.Name)
+}
+
+func (u *UnitFlapChange) GetObjType() string {
+	return "SystemD unit flapping"
+}
+
+func (u *UnitInfo) logFlapping(start, end time.Time) {
+	obj := &UnitFlapChange{
+		Name:         u.Name,
+		Flapping:     u.Flapping,
+		StateChanges: len(u.changes),
+		NRestarts:    u.NRestarts,
+	}
+
+	go func(obj *UnitFlapChange, start, end time.Time) {
+		le := logging.NewLogEvent(obj.LogLine(), obj)
+		le.StartTime = start
+		le.EndTime = end
+		le.Duration = end.Sub(start)
+		logging.Logs.AddLogEvent(le)
+	}(obj, start, end)
+}
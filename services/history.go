@@ -0,0 +1,79 @@
+// Bounded history of selected unit properties, to see how a unit evolved during boot.
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Properties whose changes are recorded for every watched unit. Properties that change on every
+// update, like MemoryCurrent, would push everything else out of the history.
+var HISTORY_PROPERTIES = []string{"MainPID", "NRestarts", "Result"}
+
+// Maximum number of changes kept per unit, older changes are dropped first.
+var HISTORY_LENGTH = 100
+
+type PropertyChange struct {
+	Time     time.Time `json:"time"`
+	Property string    `json:"property"`
+	From     string    `json:"from"` // empty for the first observation
+	To       string    `json:"to"`
+}
+
+func (c PropertyChange) String() string {
+	if c.From == "" {
+		return fmt.Sprintf("%s %s: %s", c.Time.Format("15:04:05.000"), c.Property, c.To)
+	}
+	return fmt.Sprintf("%s %s: %s -> %s", c.Time.Format("15:04:05.000"), c.Property, c.From, c.To)
+}
+
+// Records changes of HISTORY_PROPERTIES. Must be called with uw.mu held.
+func (u *UnitInfo) updateHistory(properties map[string]interface{}, now time.Time) {
+	if !SYSTEMD_ACCESS || HISTORY_LENGTH <= 0 {
+		return
+	}
+	if u.lastValues == nil {
+		u.lastValues = make(map[string]string)
+	}
+
+	for _, prop := range HISTORY_PROPERTIES {
+		v, ok := properties[prop]
+		if !ok || !PropertyAllowed(prop) {
+			continue
+		}
+		val := formatHistoryValue(v)
+		last, seen := u.lastValues[prop]
+		if seen && last == val {
+			continue
+		}
+		u.lastValues[prop] = val
+		u.history = append(u.history, PropertyChange{Time: now, Property: prop, From: last, To: val})
+	}
+
+	if over := len(u.history) - HISTORY_LENGTH; over > 0 {
+		u.history = append([]PropertyChange{}, u.history[over:]...)
+	}
+}
+
+// systemd reports unset numeric properties (e.g. MemoryCurrent without accounting) as the max value.
+func formatHistoryValue(v interface{}) string {
+	if n, ok := v.(uint64); ok && n == math.MaxUint64 {
+		return "[not set]"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Recorded property changes of a watched unit, oldest first.
+func (uw *UnitWatcher) PropertyHistory(name string) ([]PropertyChange, error) {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	for _, u := range uw.Units {
+		if u.Name == name {
+			history := make([]PropertyChange, len(u.history))
+			copy(history, u.history)
+			return history, nil
+		}
+	}
+	return nil, ErrUnitNotWatched
+}
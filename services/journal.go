@@ -0,0 +1,135 @@
+// Recent journal lines of units, to make failures diagnosable from the console.
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Number of journal lines to show for a unit.
+var JOURNAL_LINES = 10
+
+// Source of journal lines. Replaced by a fake when there is no journal to read from.
+var JOURNAL JournalReader = Journalctl{}
+
+type JournalEntry struct {
+	Time     time.Time `json:"time"`
+	Priority int       `json:"priority"` // syslog priority, 0 (emerg) to 7 (debug)
+	Message  string    `json:"message"`
+}
+
+func (e JournalEntry) String() string {
+	return fmt.Sprintf("%s %s", e.Time.Format("15:04:05.000"), e.Message)
+}
+
+type JournalReader interface {
+	// Returns up to n of the most recent journal entries for a unit, oldest first.
+	Lines(unit string, n int) ([]JournalEntry, error)
+}
+
+// Reads the journal through `journalctl -o json`.
+type Journalctl struct{}
+
+func (j Journalctl) Lines(unit string, n int) ([]JournalEntry, error) {
+	cmd := exec.Command("journalctl", "-u", unit, "-n", strconv.Itoa(n), "-o", "json", "--no-pager")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Reading journal of %s: %w", unit, err)
+	}
+	return parseJournalJSON(out)
+}
+
+// Parses the output of `journalctl -o json`, which is one JSON object per line.
+// A last line that can't be parsed is skipped, journalctl may have been cut off while writing it.
+func parseJournalJSON(out []byte) ([]JournalEntry, error) {
+	entries := make([]JournalEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var parseErr error // of the previous line, only returned if it wasn't the last one
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if parseErr != nil {
+			return entries, parseErr
+		}
+
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(line, &fields); err != nil {
+			parseErr = fmt.Errorf("Parsing journal entry: %w", err)
+			continue
+		}
+
+		entry := JournalEntry{Priority: 6}
+		if ts, ok := fields["__REALTIME_TIMESTAMP"].(string); ok {
+			if usec, err := strconv.ParseUint(ts, 10, 64); err == nil {
+				entry.Time = realtimeToTime(usec)
+			}
+		}
+		if p, ok := fields["PRIORITY"].(string); ok {
+			if prio, err := strconv.Atoi(p); err == nil {
+				entry.Priority = prio
+			}
+		}
+		switch msg := fields["MESSAGE"].(type) {
+		case string:
+			entry.Message = msg
+		case []interface{}: // non-UTF-8 messages are serialized as byte arrays
+			b := make([]byte, 0, len(msg))
+			for _, c := range msg {
+				if f, ok := c.(float64); ok {
+					b = append(b, byte(f))
+				}
+			}
+			entry.Message = string(b)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// In-memory journal, for running without journald.
+type FakeJournal struct {
+	Entries map[string][]JournalEntry
+	mu      sync.Mutex
+}
+
+func (j *FakeJournal) Lines(unit string, n int) ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := j.Entries[unit]
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return append([]JournalEntry{}, entries...), nil
+}
+
+func (j *FakeJournal) add(unit string, entry JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Entries[unit] = append(j.Entries[unit], entry)
+}
+
+// Recent journal lines of a watched unit, empty if the user doesn't have access to systemd details.
+func (uw *UnitWatcher) RecentJournal(unit string) ([]JournalEntry, error) {
+	if !uw.isWatched(unit) {
+		return nil, ErrUnitNotWatched
+	}
+	return recentJournal(unit)
+}
+
+func recentJournal(unit string) ([]JournalEntry, error) {
+	if !SYSTEMD_ACCESS {
+		return []JournalEntry{}, nil
+	}
+	return JOURNAL.Lines(unit, JOURNAL_LINES)
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJournalJSON(t *testing.T) {
+	const line1 = `{"__REALTIME_TIMESTAMP":"1700000000123456","PRIORITY":"6","MESSAGE":"Started a.service."}`
+	const line2 = `{"__REALTIME_TIMESTAMP":"1700000001000000","PRIORITY":"3","MESSAGE":"a.service: Failed with result 'exit-code'."}`
+
+	tests := []struct {
+		name    string
+		out     string
+		want    []JournalEntry
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			out:  "",
+			want: []JournalEntry{},
+		},
+		{
+			name: "multiple lines",
+			out:  line1 + "\n" + line2 + "\n",
+			want: []JournalEntry{
+				{Time: time.UnixMicro(1700000000123456), Priority: 6, Message: "Started a.service."},
+				{Time: time.UnixMicro(1700000001000000), Priority: 3, Message: "a.service: Failed with result 'exit-code'."},
+			},
+		},
+		{
+			name: "no trailing newline",
+			out:  line1 + "\n" + line2,
+			want: []JournalEntry{
+				{Time: time.UnixMicro(1700000000123456), Priority: 6, Message: "Started a.service."},
+				{Time: time.UnixMicro(1700000001000000), Priority: 3, Message: "a.service: Failed with result 'exit-code'."},
+			},
+		},
+		{
+			name: "binary message",
+			// "caf\xe9 \xff", not valid UTF-8, so journalctl writes the bytes as an array
+			out: `{"__REALTIME_TIMESTAMP":"1700000000000000","PRIORITY":"4","MESSAGE":[99,97,102,233,32,255]}` + "\n",
+			want: []JournalEntry{
+				{Time: time.UnixMicro(1700000000000000), Priority: 4, Message: "caf\xe9 \xff"},
+			},
+		},
+		{
+			name: "missing fields",
+			out:  `{"MESSAGE":"no time or priority"}` + "\n",
+			want: []JournalEntry{{Priority: 6, Message: "no time or priority"}},
+		},
+		{
+			name: "truncated last line",
+			out:  line1 + "\n" + line2[:len(line2)/2],
+			want: []JournalEntry{
+				{Time: time.UnixMicro(1700000000123456), Priority: 6, Message: "Started a.service."},
+			},
+		},
+		{
+			name:    "broken line in between",
+			out:     line1[:len(line1)/2] + "\n" + line2 + "\n",
+			want:    []JournalEntry{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseJournalJSON([]byte(tt.out))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJournalJSON() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(entries) != len(tt.want) {
+				t.Fatalf("parseJournalJSON() = %v, want %v", entries, tt.want)
+			}
+			for i, e := range entries {
+				w := tt.want[i]
+				if !e.Time.Equal(w.Time) || e.Priority != w.Priority || e.Message != w.Message {
+					t.Errorf("entry %d = %+v, want %+v", i, e, w)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeJournalLines(t *testing.T) {
+	j := &FakeJournal{Entries: make(map[string][]JournalEntry)}
+	for i := 0; i < 5; i++ {
+		j.add("a.service", JournalEntry{Message: strings.Repeat("x", i)})
+	}
+	lines, err := j.Lines("a.service", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 || lines[0].Message != "xx" || lines[2].Message != "xxxx" {
+		t.Errorf("Lines(a.service, 3) = %v, want the last 3 entries", lines)
+	}
+	if lines, _ := j.Lines("b.service", 3); len(lines) != 0 {
+		t.Errorf("Lines(b.service, 3) = %v, want none", lines)
+	}
+}
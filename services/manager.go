@@ -0,0 +1,129 @@
+// Global state of the systemd manager: system state, pending jobs and failed units.
+package services
+
+import (
+	"fmt"
+	"log"
+	"spirit-box/logging"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Job struct {
+	Id      uint32 `json:"id"`
+	Unit    string `json:"unit"`
+	JobType string `json:"jobType"` // e.g. start, stop
+	Status  string `json:"status"`  // waiting or running
+}
+
+type ManagerStatus struct {
+	SystemState string   `json:"systemState"` // e.g. starting, running, degraded
+	Jobs        []Job    `json:"jobs"`
+	FailedUnits []string `json:"failedUnits"` // system-wide, not only watched units
+	Error       string   `json:"error,omitempty"`
+}
+
+// One line summary, e.g. "System: starting, 12 jobs pending, 1 failed unit".
+func (ms ManagerStatus) Summary() string {
+	if ms.Error != "" && ms.SystemState == "" {
+		return "System: unknown"
+	}
+	failed := "failed units"
+	if len(ms.FailedUnits) == 1 {
+		failed = "failed unit"
+	}
+	jobs := "jobs"
+	if len(ms.Jobs) == 1 {
+		jobs = "job"
+	}
+	return fmt.Sprintf("System: %s, %d %s pending, %d %s",
+		ms.SystemState, len(ms.Jobs), jobs, len(ms.FailedUnits), failed)
+}
+
+type SystemStateChange struct {
+	SystemState [2]string `json:"systemState"`
+	FailedUnits []string  `json:"failedUnits"`
+}
+
+func (s *SystemStateChange) LogLine() string {
+	return fmt.Sprintf("System state changed from %s to %s.", s.SystemState[0], s.SystemState[1])
+}
+
+func (s *SystemStateChange) GetObjType() string {
+	return "System state change"
+}
+
+// Reads the manager's state. Must be called with uw.mu held.
+func (uw *UnitWatcher) updateManagerStatus() {
+	status := ManagerStatus{Jobs: []Job{}, FailedUnits: []string{}}
+	errs := make([]string, 0)
+
+	state, err := uw.DConn.GetManagerProperty("SystemState")
+	if err != nil {
+		errs = append(errs, err.Error())
+		status.SystemState = uw.managerStatus.SystemState // keep the last known state
+	} else if unquoted, err := strconv.Unquote(state); err == nil {
+		status.SystemState = unquoted
+	} else {
+		status.SystemState = state
+	}
+
+	jobs, err := uw.DConn.ListJobs()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, j := range jobs {
+		status.Jobs = append(status.Jobs, Job{Id: j.Id, Unit: j.Unit, JobType: j.JobType, Status: j.Status})
+	}
+
+	failed, err := uw.DConn.ListUnitsFiltered([]string{"failed"})
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, u := range failed {
+		status.FailedUnits = append(status.FailedUnits, u.Name)
+	}
+
+	if len(errs) > 0 {
+		status.Error = strings.Join(errs, "; ")
+		if status.Error != uw.managerStatus.Error { // polled every tick while systemd is unreachable
+			log.Printf("Reading systemd manager status: %s", status.Error)
+		}
+	}
+
+	if status.SystemState != "" && status.SystemState != uw.managerStatus.SystemState {
+		obj := &SystemStateChange{
+			SystemState: [2]string{uw.managerStatus.SystemState, status.SystemState},
+			FailedUnits: status.FailedUnits,
+		}
+		go func(obj *SystemStateChange, at time.Time) {
+			le := logging.NewLogEvent(obj.LogLine(), obj)
+			le.StartTime = at
+			le.EndTime = at
+			logging.Logs.AddLogEvent(le)
+		}(obj, time.Now())
+	}
+
+	uw.managerStatus = status
+}
+
+// Summary line followed by up to maxJobs pending jobs, for the TUI headers.
+func (ms ManagerStatus) Header(maxJobs int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s", ms.Summary())
+	for i, j := range ms.Jobs {
+		if i == maxJobs {
+			fmt.Fprintf(&b, "\n  ... and %d more", len(ms.Jobs)-maxJobs)
+			break
+		}
+		fmt.Fprintf(&b, "\n  %s %s (%s)", j.JobType, j.Unit, j.Status)
+	}
+	return b.String()
+}
+
+func (uw *UnitWatcher) GetManagerStatus() ManagerStatus {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	return uw.managerStatus
+}
@@ -0,0 +1,106 @@
+// Persistence of units added or removed at run time, as a config override file.
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Path of the override file runtime additions and removals are written to. Not persisted if empty.
+var RUNTIME_OVERRIDE string
+
+// Reads RUNTIME_OVERRIDE as raw fields, so that fields other than unitSpecs and removedUnits
+// (e.g. configOverride) are written back as they were. Also returns the file's mode.
+func readRuntimeOverride() (map[string]json.RawMessage, os.FileMode, error) {
+	fields := make(map[string]json.RawMessage)
+	info, err := os.Stat(RUNTIME_OVERRIDE)
+	if errors.Is(err, os.ErrNotExist) {
+		return fields, 0644, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	bytes, err := os.ReadFile(RUNTIME_OVERRIDE)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = json.Unmarshal(bytes, &fields)
+	return fields, info.Mode().Perm(), err
+}
+
+// Records that a unit was added (spec) or removed (spec with only the name set).
+func persistWatchChange(spec UnitSpec, removed bool) error {
+	if RUNTIME_OVERRIDE == "" {
+		return nil
+	}
+
+	fields, mode, err := readRuntimeOverride()
+	if err != nil {
+		return fmt.Errorf("Reading runtime override %s: %w", RUNTIME_OVERRIDE, err)
+	}
+	oldSpecs := make([]UnitSpec, 0)
+	oldRemoved := make([]string, 0)
+	if raw, ok := fields["unitSpecs"]; ok {
+		err = json.Unmarshal(raw, &oldSpecs)
+	}
+	if raw, ok := fields["removedUnits"]; ok && err == nil {
+		err = json.Unmarshal(raw, &oldRemoved)
+	}
+	if err != nil {
+		return fmt.Errorf("Reading runtime override %s: %w", RUNTIME_OVERRIDE, err)
+	}
+
+	specs := make([]UnitSpec, 0, len(oldSpecs))
+	for _, s := range oldSpecs {
+		if s.Name != spec.Name {
+			specs = append(specs, s)
+		}
+	}
+	removedUnits := make([]string, 0, len(oldRemoved))
+	for _, name := range oldRemoved {
+		if name != spec.Name {
+			removedUnits = append(removedUnits, name)
+		}
+	}
+	if removed {
+		removedUnits = append(removedUnits, spec.Name)
+	} else {
+		specs = append(specs, spec)
+	}
+	if fields["unitSpecs"], err = json.Marshal(specs); err != nil {
+		return err
+	}
+	if fields["removedUnits"], err = json.Marshal(removedUnits); err != nil {
+		return err
+	}
+
+	bytes, err := json.MarshalIndent(fields, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so a crash never leaves a truncated config file behind
+	tmp, err := os.CreateTemp(filepath.Dir(RUNTIME_OVERRIDE), ".runtime-override-*")
+	if err != nil {
+		return fmt.Errorf("Writing runtime override %s: %w", RUNTIME_OVERRIDE, err)
+	}
+	_, err = tmp.Write(append(bytes, '\n'))
+	if err == nil {
+		// CreateTemp creates the file with mode 0600
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), RUNTIME_OVERRIDE)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Writing runtime override %s: %w", RUNTIME_OVERRIDE, err)
+	}
+	return nil
+}
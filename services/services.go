@@ -9,10 +9,20 @@ import (
 	"sync"
 	"time"
 
-	"github.com/coreos/go-systemd/v22/dbus"
+	"golang.org/x/sys/unix"
 )
 
 var SYSTEMD_START_TIME time.Time
+
+// Wall clock time at monotonic zero, taken once when the watcher is created.
+// Unit timestamps are derived from their monotonic values and this reference
+// so that clock steps during boot (NTP, RTC sync) don't distort durations.
+var MONOTONIC_ANCHOR time.Time
+
+// Differences between systemd's realtime and monotonic timestamps larger than
+// this are reported as clock jumps.
+const clockJumpThreshold = time.Second
+
 var UNIT_SPECS []UnitSpec
 var SYSTEMD_ACCESS bool
 
@@ -20,17 +30,26 @@ type UnitSpec struct {
 	Name            string `json:"name"`
 	Desc            string `json:"desc"`
 	SubStateDesired string `json:"subStateDesired"`
+	UnitFileState   string `json:"unitFileState,omitempty"` // e.g. enabled, masked or static, not checked if empty
 }
 
 func (u UnitSpec) ToString() string {
-	return u.Name + u.Desc + u.SubStateDesired
+	return u.Name + u.Desc + u.SubStateDesired + u.UnitFileState
 }
 
 type UnitWatcher struct {
-	Units   []*UnitInfo
-	DConn   *dbus.Conn
-	started time.Time
-	mu      sync.Mutex
+	Units         []*UnitInfo
+	DConn         SystemdBackend
+	started       time.Time
+	mu            sync.Mutex
+	bootPhases    []*BootPhase
+	bootStamps    map[string]managerTimestamp // start timestamps of the boot phases, read once
+	bootErr       string                      // error from the last read of the boot timestamps, only logged when it changes
+	bootFinished  bool                        // FinishTimestamp has been set, boot phases won't change anymore
+	connErr       error                       // error from the last update, nil if all units could be read
+	managerStatus ManagerStatus
+	configErrors  []ConfigError           // problems with the unit specs, found at startup
+	analyzed      map[string]analyzedUnit // units that have activated, see Analyze
 }
 
 func (uw *UnitWatcher) Start(interval int) {
@@ -45,19 +64,30 @@ func (uw *UnitWatcher) Start(interval int) {
 func (uw *UnitWatcher) UpdateAll() bool {
 	uw.mu.Lock()
 	defer uw.mu.Unlock()
+	uw.updateBootPhases()
+	uw.updateManagerStatus()
 	allReady := true
+	uw.connErr = nil
 	for _, u := range uw.Units {
-		properties, err := uw.DConn.GetUnitProperties(u.Name)
+		properties, err := uw.DConn.GetAllProperties(u.Name)
+		if err == nil {
+			var states [3]string
+			states, err = unitStates(properties)
+			if err == nil {
+				u.update(states, properties)
+			}
+		}
 		if err != nil {
-			log.Fatal(err)
+			// keep the last known state, the backend reconnects on its own
+			if err.Error() != u.readErr {
+				log.Print(err)
+				u.readErr = err.Error()
+			}
+			uw.connErr = err
+			allReady = false
+			continue
 		}
-
-		// type assertions
-		s1 := assertString(properties["LoadState"])
-		s2 := assertString(properties["ActiveState"])
-		s3 := assertString(properties["SubState"])
-
-		u.update([3]string{s1, s2, s3}, properties)
+		u.readErr = ""
 		allReady = allReady && u.Ready
 	}
 
@@ -76,31 +106,100 @@ func (uw *UnitWatcher) InitializeStates() bool {
 }
 
 func (uw *UnitWatcher) InitializeState(u *UnitInfo) error {
-	properties, err := uw.DConn.GetUnitProperties(u.Name)
+	properties, err := uw.DConn.GetAllProperties(u.Name)
 	if err != nil {
 		return err
 	}
 
-	// type assertions
-	s1 := assertString(properties["LoadState"])
-	s2 := assertString(properties["ActiveState"])
-	s3 := assertString(properties["SubState"])
-	s4 := assertString(properties["Description"])
-	u.Description = s4
+	states, err := unitStates(properties)
+	if err != nil {
+		return err
+	}
+	description, err := assertString(properties["Description"])
+	if err != nil {
+		return err
+	}
+	u.Description = description
 
-	u.update([3]string{s1, s2, s3}, properties)
+	u.update(states, properties)
 	return nil
 }
 
-func (uw *UnitWatcher) AddUnit(name string) {
+// LoadState, ActiveState and SubState of a unit.
+func unitStates(properties map[string]interface{}) ([3]string, error) {
+	var states [3]string
+	for i, key := range []string{"LoadState", "ActiveState", "SubState"} {
+		s, err := assertString(properties[key])
+		if err != nil {
+			return states, fmt.Errorf("Reading %s: %w", key, err)
+		}
+		states[i] = s
+	}
+	return states, nil
+}
+
+var ErrAlreadyWatched = errors.New("Unit is already watched by spirit-box.")
+
+// Starts watching a unit. The unit stays watched after a restart if RUNTIME_OVERRIDE is set.
+func (uw *UnitWatcher) AddUnit(name string) error {
 	uw.mu.Lock()
 	defer uw.mu.Unlock()
-	newUnit := &UnitInfo{name, "watch", false, "", "", "", "", "", nil, SYSTEMD_START_TIME, uw}
+	if name == "" {
+		return errors.New("No unit name given.")
+	}
+	for _, u := range uw.Units {
+		if u.Name == name {
+			return ErrAlreadyWatched
+		}
+	}
+
+	newUnit := &UnitInfo{
+		Name:            name,
+		SubStateDesired: "watch",
+		At:              SYSTEMD_START_TIME,
+		uw:              uw,
+	}
 	err := uw.InitializeState(newUnit)
 	if err != nil {
-		return // no feedback on failure
+		return fmt.Errorf("Adding %s: %w", name, err)
+	}
+	if newUnit.LoadState == "not-found" {
+		return fmt.Errorf("Adding %s: unit not found.", name)
+	}
+	// persisted first, so a unit is only watched if it will still be watched after a restart
+	if err := persistWatchChange(UnitSpec{Name: name, SubStateDesired: "watch"}, false); err != nil {
+		return err
 	}
 	uw.Units = append(uw.Units, newUnit)
+	logMessage(fmt.Sprintf("Started watching %s.", name))
+	return nil
+}
+
+// Stops watching a unit. The unit stays removed after a restart if RUNTIME_OVERRIDE is set.
+func (uw *UnitWatcher) RemoveUnit(name string) error {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	for i, u := range uw.Units {
+		if u.Name == name {
+			if err := persistWatchChange(UnitSpec{Name: name}, true); err != nil {
+				return err
+			}
+			uw.Units = append(uw.Units[:i:i], uw.Units[i+1:]...)
+			logMessage(fmt.Sprintf("Stopped watching %s.", name))
+			return nil
+		}
+	}
+	return ErrUnitNotWatched
+}
+
+// Warning to show while systemd can't be reached, "" if everything is fine.
+func (uw *UnitWatcher) ConnectionBanner() string {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	if uw.connErr == nil {
+		return ""
+	}
+	return fmt.Sprintf("Can't reach systemd, showing last known states. (%s)", uw.connErr.Error())
 }
 
 func (uw *UnitWatcher) AllReadyStatus() string {
@@ -147,17 +246,26 @@ func (uw *UnitWatcher) NumUnits() int {
 	return len(uw.Units)
 }
 
-func NewWatcher(dConn *dbus.Conn) *UnitWatcher {
+func NewWatcher(dConn SystemdBackend) (*UnitWatcher, error) {
 	newUW := &UnitWatcher{
 		DConn:   dConn,
 		started: time.Now(),
 	}
 
-	setSystemdStartTime(dConn)
+	if err := setMonotonicAnchor(); err != nil {
+		return nil, err
+	}
+	if err := setSystemdStartTime(dConn); err != nil {
+		return nil, err
+	}
+	newUW.updateBootPhases()
+	newUW.updateManagerStatus()
 
-	newUW.Units = LoadUnitSpecs(newUW, SYSTEMD_START_TIME)
+	specs, configErrors := validateUnitSpecs(dConn, UNIT_SPECS)
+	newUW.configErrors = configErrors
+	newUW.Units = LoadUnitSpecs(newUW, specs, SYSTEMD_START_TIME)
 
-	return newUW
+	return newUW, nil
 }
 
 // Basic data for a unit's state.
@@ -170,9 +278,22 @@ type UnitInfo struct {
 	SubState        string
 	Description     string // from systemd
 	Desc            string // user-provided
+	StatusText      string // set by Type=notify services
 	Properties      map[string]interface{}
 	At              time.Time
-	uw              *UnitWatcher
+	NRestarts       uint32       // only set for services
+	Flapping        bool         // too many state changes or restarts, never ready while set
+	Details         *UnitDetails // for timers, sockets, mounts and devices, nil for other types
+
+	UnitFileState        string // e.g. enabled, disabled, static
+	UnitFileStateDesired string // not checked if empty
+	FileStateDrift       bool   // UnitFileState doesn't match UnitFileStateDesired, never ready while set
+	changes              []time.Time
+	lastChange           time.Time
+	history              []PropertyChange
+	lastValues           map[string]string // last recorded value of each history property
+	readErr              string            // error from the last update, only logged when it changes
+	uw                   *UnitWatcher
 }
 
 // Check if unit info needs to be updated, log if it was changed.
@@ -192,7 +313,32 @@ func (u *UnitInfo) update(updates [3]string, properties map[string]interface{})
 		changed = true
 	}
 
-	if u.SubStateDesired == "watch" || u.SubState == u.SubStateDesired {
+	// the first observation only sets the baseline for flap detection
+	firstUpdate := from1 == ""
+	events := 0
+	if changed && !firstUpdate {
+		events++
+	}
+	if nRestarts, ok := properties["NRestarts"].(uint32); ok {
+		if nRestarts > u.NRestarts && !firstUpdate {
+			events += int(nRestarts - u.NRestarts)
+		}
+		u.NRestarts = nRestarts
+	}
+	u.updateFlapping(time.Now(), events)
+	u.updateHistory(properties, time.Now())
+	if SYSTEMD_ACCESS {
+		u.Details = readDetails(u.Name, properties)
+	}
+	u.updateFileState(properties, time.Now())
+
+	if statusText, ok := properties["StatusText"].(string); ok && SYSTEMD_ACCESS && PropertyAllowed("StatusText") {
+		u.StatusText = statusText
+	}
+
+	if u.SubStateDesired == "watch" {
+		u.Ready = true
+	} else if u.SubState == u.SubStateDesired && !u.Flapping && !u.FileStateDrift {
 		u.Ready = true
 	} else {
 		u.Ready = false
@@ -200,19 +346,27 @@ func (u *UnitInfo) update(updates [3]string, properties map[string]interface{})
 
 	if changed {
 		obj := u.GetStateChange(from1, from2, from3, from4)
-		timeChanged := getTimeOfStateChange(updates[1], properties)
-
-		go func(obj *UnitStateChange, timeChanged, at time.Time, unitName string) {
+		timeChanged, clockJump := getTimeOfStateChange(updates[1], properties)
+
+		go func(obj *UnitStateChange, timeChanged, at time.Time, clockJump time.Duration, unitName string) {
+			if obj.ActiveState[1] == "failed" {
+				journal, err := recentJournal(unitName)
+				if err != nil {
+					log.Print(err)
+				}
+				obj.Journal = journal
+			}
 			le := logging.NewLogEvent(fmt.Sprintf("%s state change.", unitName), obj)
 			le.EndTime = timeChanged
 			le.StartTime = at
 			le.Duration = timeChanged.Sub(at)
+			le.ClockJump = clockJump
 			logging.Logs.AddLogEvent(le)
-		}(obj, timeChanged, u.At, u.Name)
+		}(obj, timeChanged, u.At, clockJump, u.Name)
 
 		u.At = timeChanged
 		if SYSTEMD_ACCESS {
-			u.Properties = properties
+			u.Properties = FilterProperties(properties)
 		}
 	}
 
@@ -220,13 +374,15 @@ func (u *UnitInfo) update(updates [3]string, properties map[string]interface{})
 }
 
 type UnitStateChange struct {
-	Name            string    `json:"name"`
-	SubStateDesired string    `json:"subStateDesired"`
-	Ready           [2]bool   `json:"ready"`
-	LoadState       [2]string `json:"loadState"`
-	ActiveState     [2]string `json:"activeState"`
-	SubState        [2]string `json:"subState"`
-	Description     string    `json:"description"`
+	Name            string         `json:"name"`
+	SubStateDesired string         `json:"subStateDesired"`
+	Ready           [2]bool        `json:"ready"`
+	LoadState       [2]string      `json:"loadState"`
+	ActiveState     [2]string      `json:"activeState"`
+	SubState        [2]string      `json:"subState"`
+	Description     string         `json:"description"`
+	StatusText      string         `json:"statusText,omitempty"`
+	Journal         []JournalEntry `json:"journal,omitempty"` // recent journal lines, only for failures
 }
 
 func (u *UnitInfo) GetStateChange(from1, from2, from3 string, from4 bool) *UnitStateChange {
@@ -238,54 +394,56 @@ func (u *UnitInfo) GetStateChange(from1, from2, from3 string, from4 bool) *UnitS
 		SubState:        [2]string{from3, u.SubState},
 		Ready:           [2]bool{from4, u.Ready},
 		Description:     u.Description,
+		StatusText:      u.StatusText,
 	}
 }
 
 func (u *UnitStateChange) LogLine() string {
-	return fmt.Sprintf("%s: %s %s %s %s", u.Name, u.LoadState[1], u.ActiveState[1], u.SubState[1], u.Description)
+	line := fmt.Sprintf("%s: %s %s %s %s", u.Name, u.LoadState[1], u.ActiveState[1], u.SubState[1], u.Description)
+	if u.StatusText != "" {
+		line += fmt.Sprintf(" (%s)", u.StatusText)
+	}
+	for _, entry := range u.Journal {
+		line += "\n\t" + entry.String()
+	}
+	return line
 }
 
 func (u *UnitStateChange) GetObjType() string {
 	return "SystemD unit state change"
 }
 
-func LoadUnitSpecs(uw *UnitWatcher, startTime time.Time) []*UnitInfo {
-	specs := UNIT_SPECS
+func LoadUnitSpecs(uw *UnitWatcher, specs []UnitSpec, startTime time.Time) []*UnitInfo {
 	units := make([]*UnitInfo, 0)
 
 	for _, s := range specs {
 		units = append(units, &UnitInfo{
-			s.Name,
-			s.SubStateDesired,
-			false,
-			"",
-			"",
-			"",
-			"",
-			s.Desc,
-			nil,
-			startTime,
-			uw,
+			Name:                 s.Name,
+			SubStateDesired:      s.SubStateDesired,
+			UnitFileStateDesired: s.UnitFileState,
+			Desc:                 s.Desc,
+			At:                   startTime,
+			uw:                   uw,
 		})
 	}
 
 	return units
 }
 
-func assertString(obj interface{}) string {
+func assertString(obj interface{}) (string, error) {
 	s, ok := obj.(string)
 	if !ok {
-		log.Fatal(errors.New(fmt.Sprintf("Type assertion failed: %v is a %T.", obj, obj)))
+		return "", fmt.Errorf("Type assertion failed: %v is a %T.", obj, obj)
 	}
-	return s
+	return s, nil
 }
 
-func assertUint64(obj interface{}) uint64 {
+func assertUint64(obj interface{}) (uint64, error) {
 	i, ok := obj.(uint64)
 	if !ok {
-		log.Fatal(errors.New(fmt.Sprintf("Type assertion failed: %v is a %T.", obj, obj)))
+		return 0, fmt.Errorf("Type assertion failed: %v is a %T.", obj, obj)
 	}
-	return i
+	return i, nil
 }
 
 func convertRealtime(val uint64) (int64, int64) { // may have to account for different levels of clock precision across machines
@@ -296,47 +454,112 @@ func convertRealtime(val uint64) (int64, int64) { // may have to account for dif
 	return sec, nsec
 }
 
-func getTimeOfStateChange(activeState string, properties map[string]interface{}) time.Time {
-	var key string
+func realtimeToTime(val uint64) time.Time {
+	sec, nsec := convertRealtime(val)
+	return time.Unix(sec, nsec)
+}
+
+// Name of the property holding the time a unit entered the active state, "" if the state is unknown.
+func timestampProperty(activeState string) string {
 	switch activeState {
 	case "inactive", "failed":
-		key = "InactiveEnterTimestamp"
+		return "InactiveEnterTimestamp"
 	case "activating":
-		key = "InactiveExitTimestamp"
+		return "InactiveExitTimestamp"
 	case "active":
-		key = "ActiveEnterTimestamp"
+		return "ActiveEnterTimestamp"
 	case "deactivating":
-		key = "ActiveExitTimestamp"
-	default:
-		log.Fatalf("%s is an unrecognized active state.", activeState)
+		return "ActiveExitTimestamp"
+	}
+	return ""
+}
+
+// Returns the time of the unit's last state change on the monotonic timeline,
+// and the offset of systemd's wall clock timestamp from it if a clock jump was detected.
+func getTimeOfStateChange(activeState string, properties map[string]interface{}) (time.Time, time.Duration) {
+	key := timestampProperty(activeState)
+	if key == "" {
+		// e.g. reloading, maintenance or states added by newer systemd versions
+		log.Printf("%s is an unrecognized active state, using the current time.", activeState)
+		return time.Now(), 0
 	}
 
-	realTime := assertUint64(properties[key])
+	monotonic, err := assertUint64(properties[key+"Monotonic"])
+	if err != nil {
+		log.Printf("Reading %sMonotonic: %s Using the current time.", key, err.Error())
+		return time.Now(), 0
+	}
 
-	if realTime == 0 { // state is the same as it was when it started.
-		return SYSTEMD_START_TIME
+	if monotonic == 0 { // state is the same as it was when it started.
+		return SYSTEMD_START_TIME, 0
 	}
-	sec, nsec := convertRealtime(realTime)
-	return time.Unix(sec, nsec)
+	at := monotonicToTime(monotonic)
+
+	realtime, _ := assertUint64(properties[key]) // 0 skips the clock jump check
+	return at, clockJump(realtime, at)
 }
 
-func setSystemdStartTime(dConn *dbus.Conn) {
+func setSystemdStartTime(dConn SystemdBackend) error {
 	props, err := dConn.GetAllProperties("-.slice")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	realTime := assertUint64(props["ActiveEnterTimestamp"])
-	sec, nsec := convertRealtime(realTime)
-	SYSTEMD_START_TIME = time.Unix(sec, nsec)
+	monotonic, err := assertUint64(props["ActiveEnterTimestampMonotonic"])
+	if err != nil {
+		return fmt.Errorf("Reading systemd's start time: %w", err)
+	}
+	SYSTEMD_START_TIME = monotonicToTime(monotonic)
 
 	// log when systemd was started
 	msg := "SystemD was started."
-	msgLog := logging.NewLogEvent(msg, &logging.MessageLog{msg, "SystemD"})
+	msgLog := logging.NewLogEvent(msg, &logging.MessageLog{Message: msg, Name: "SystemD"})
 
 	msgLog.StartTime = SYSTEMD_START_TIME
 	msgLog.EndTime = SYSTEMD_START_TIME
 	msgLog.Duration = SYSTEMD_START_TIME.Sub(SYSTEMD_START_TIME)
+	realtime, _ := assertUint64(props["ActiveEnterTimestamp"]) // 0 skips the clock jump check
+	msgLog.ClockJump = clockJump(realtime, SYSTEMD_START_TIME)
 
 	logging.Logs.AddLogEvent(msgLog)
+	return nil
+}
+
+func setMonotonicAnchor() error {
+	now, err := monotonicNow()
+	if err != nil {
+		return err
+	}
+	MONOTONIC_ANCHOR = time.Now().Round(0).Add(-monotonicToDuration(now))
+	return nil
+}
+
+// Current CLOCK_MONOTONIC time in microseconds, the clock systemd's monotonic timestamps use.
+func monotonicNow() (uint64, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, fmt.Errorf("Reading the monotonic clock: %w", err)
+	}
+	return uint64(ts.Nano() / 1000), nil
+}
+
+func monotonicToTime(val uint64) time.Time {
+	return MONOTONIC_ANCHOR.Add(monotonicToDuration(val))
+}
+
+func monotonicToDuration(val uint64) time.Duration {
+	return time.Duration(val) * time.Microsecond
+}
+
+// Compares a realtime timestamp with the same moment on the monotonic timeline.
+// Returns 0 unless the wall clock was stepped in between.
+func clockJump(realTime uint64, at time.Time) time.Duration {
+	if realTime == 0 {
+		return 0
+	}
+	jump := realtimeToTime(realTime).Sub(at)
+	if jump < clockJumpThreshold && jump > -clockJumpThreshold {
+		return 0
+	}
+	return jump
 }
@@ -0,0 +1,527 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"spirit-box/logging"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+func TestMain(m *testing.M) {
+	logging.InitLogger()
+	SYSTEMD_ACCESS = true
+	os.Exit(m.Run())
+}
+
+// Sets a package variable for the duration of a test.
+func set[T any](t *testing.T, v *T, val T) {
+	old := *v
+	*v = val
+	t.Cleanup(func() { *v = old })
+}
+
+// Creates a watcher for specs against a fake with the given units.
+func newTestWatcher(t *testing.T, specs []UnitSpec, units ...FakeUnitSpec) (*UnitWatcher, *FakeSystemd) {
+	set(t, &UNIT_SPECS, specs)
+	fake := NewFakeSystemd()
+	for _, u := range units {
+		fake.AddUnit(u)
+	}
+	uw, err := NewWatcher(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uw.InitializeStates()
+	return uw, fake
+}
+
+func findUnit(t *testing.T, uw *UnitWatcher, name string) *UnitInfo {
+	for _, u := range uw.Units {
+		if u.Name == name {
+			return u
+		}
+	}
+	t.Fatalf("%s is not watched", name)
+	return nil
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        UnitSpec
+		unit        FakeUnitSpec
+		transitions []FakeTransition
+		want        bool
+	}{
+		{
+			name: "running as desired",
+			spec: UnitSpec{Name: "a.service", SubStateDesired: "running"},
+			unit: FakeUnitSpec{Name: "a.service", ActiveState: "active", SubState: "running"},
+			want: true,
+		},
+		{
+			name: "dead",
+			spec: UnitSpec{Name: "a.service", SubStateDesired: "running"},
+			unit: FakeUnitSpec{Name: "a.service"},
+			want: false,
+		},
+		{
+			name: "started later",
+			spec: UnitSpec{Name: "a.service", SubStateDesired: "running"},
+			unit: FakeUnitSpec{Name: "a.service"},
+			transitions: []FakeTransition{
+				{Unit: "a.service", ActiveState: "activating", SubState: "start"},
+				{Unit: "a.service", ActiveState: "active", SubState: "running"},
+			},
+			want: true,
+		},
+		{
+			name: "failed later",
+			spec: UnitSpec{Name: "a.service", SubStateDesired: "running"},
+			unit: FakeUnitSpec{Name: "a.service", ActiveState: "active", SubState: "running"},
+			transitions: []FakeTransition{
+				{Unit: "a.service", ActiveState: "failed", SubState: "failed", Message: "exited with status 1"},
+			},
+			want: false,
+		},
+		{
+			name: "watched only",
+			spec: UnitSpec{Name: "a.service", SubStateDesired: "watch"},
+			unit: FakeUnitSpec{Name: "a.service", ActiveState: "failed", SubState: "failed"},
+			want: true,
+		},
+		{
+			name: "unit file state as expected",
+			spec: UnitSpec{Name: "a.service", SubStateDesired: "running", UnitFileState: "enabled"},
+			unit: FakeUnitSpec{Name: "a.service", ActiveState: "active", SubState: "running",
+				Properties: map[string]interface{}{"UnitFileState": "enabled"}},
+			want: true,
+		},
+		{
+			name: "unit file state drift",
+			spec: UnitSpec{Name: "a.service", SubStateDesired: "running", UnitFileState: "enabled"},
+			unit: FakeUnitSpec{Name: "a.service", ActiveState: "active", SubState: "running",
+				Properties: map[string]interface{}{"UnitFileState": "disabled"}},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uw, fake := newTestWatcher(t, []UnitSpec{tt.spec}, tt.unit)
+			for _, tr := range tt.transitions {
+				fake.AddTransition(tr)
+			}
+			ready := uw.UpdateAll()
+			if ready != tt.want || uw.AllReady() != tt.want {
+				t.Errorf("UpdateAll() = %v, AllReady() = %v, want %v", ready, uw.AllReady(), tt.want)
+			}
+			u := findUnit(t, uw, tt.spec.Name)
+			if u.Ready != tt.want {
+				t.Errorf("Ready = %v in state %s %s, want %v", u.Ready, u.ActiveState, u.SubState, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		settle    bool // FLAP_SETTLE_TIME is 0, so the next quiet update settles the unit
+		restarts  int
+		want      bool
+	}{
+		{name: "below threshold", threshold: 3, restarts: 2, want: false},
+		{name: "at threshold", threshold: 3, restarts: 3, want: true},
+		{name: "settled", threshold: 3, restarts: 3, settle: true, want: false},
+		{name: "disabled", threshold: 0, restarts: 10, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set(t, &FLAP_THRESHOLD, tt.threshold)
+			set(t, &FLAP_SETTLE_TIME, FLAP_SETTLE_TIME)
+			uw, fake := newTestWatcher(t,
+				[]UnitSpec{{Name: "a.service", SubStateDesired: "running"}},
+				FakeUnitSpec{Name: "a.service", ActiveState: "active", SubState: "running"})
+
+			for i := 0; i < tt.restarts; i++ {
+				fake.AddTransition(FakeTransition{Unit: "a.service", Restart: true})
+				uw.UpdateAll()
+			}
+			if tt.settle {
+				FLAP_SETTLE_TIME = 0
+				uw.UpdateAll()
+			}
+
+			u := findUnit(t, uw, "a.service")
+			if u.Flapping != tt.want {
+				t.Errorf("Flapping = %v after %d restarts, want %v", u.Flapping, tt.restarts, tt.want)
+			}
+			if u.Ready == tt.want {
+				t.Errorf("Ready = %v, want %v", u.Ready, !tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		disabled  bool
+		unit      string
+		action    string
+		state     string // ActiveState of the unit before the action
+		wantErr   error
+		wantState string
+	}{
+		{name: "disabled", disabled: true, unit: "a.service", action: "start", state: "inactive", wantErr: ErrActionsDisabled, wantState: "inactive"},
+		{name: "not watched", unit: "b.service", action: "start", state: "inactive", wantErr: ErrUnitNotWatched, wantState: "inactive"},
+		{name: "unknown action", unit: "a.service", action: "kill", state: "inactive", wantErr: ErrUnknownAction, wantState: "inactive"},
+		{name: "start", unit: "a.service", action: "start", state: "inactive", wantState: "active"},
+		{name: "stop", unit: "a.service", action: "stop", state: "active", wantState: "inactive"},
+		{name: "restart", unit: "a.service", action: "restart", state: "active", wantState: "active"},
+		{name: "reset-failed", unit: "a.service", action: "reset-failed", state: "failed", wantState: "inactive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set(t, &UNIT_ACTIONS, !tt.disabled)
+			subState := map[string]string{"inactive": "dead", "active": "running", "failed": "failed"}[tt.state]
+			uw, _ := newTestWatcher(t,
+				[]UnitSpec{{Name: "a.service", SubStateDesired: "running"}},
+				FakeUnitSpec{Name: "a.service", ActiveState: tt.state, SubState: subState},
+				FakeUnitSpec{Name: "b.service", ActiveState: tt.state, SubState: subState})
+
+			result, err := uw.RunAction(tt.unit, tt.action)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RunAction(%s, %s) error = %v, want %v", tt.unit, tt.action, err, tt.wantErr)
+			}
+			if err == nil && result != "done" {
+				t.Errorf("RunAction(%s, %s) = %s, want done", tt.unit, tt.action, result)
+			}
+
+			props, _ := uw.DConn.GetAllProperties(tt.unit)
+			if props["ActiveState"] != tt.wantState {
+				t.Errorf("%s is %s after %s, want %s", tt.unit, props["ActiveState"], tt.action, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestValidateUnitSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		specs     []UnitSpec
+		wantUnits int
+		wantErrs  []string // units with a config error, in order
+	}{
+		{
+			name:      "valid",
+			specs:     []UnitSpec{{Name: "a.service", SubStateDesired: "running"}, {Name: "b.mount", SubStateDesired: "mounted"}},
+			wantUnits: 2,
+		},
+		{
+			name:      "watch",
+			specs:     []UnitSpec{{Name: "a.service", SubStateDesired: "watch"}},
+			wantUnits: 1,
+		},
+		{
+			name:      "duplicate",
+			specs:     []UnitSpec{{Name: "a.service", SubStateDesired: "running"}, {Name: "a.service", SubStateDesired: "exited"}},
+			wantUnits: 1,
+			wantErrs:  []string{"a.service"},
+		},
+		{
+			name:      "no substate",
+			specs:     []UnitSpec{{Name: "a.service"}},
+			wantUnits: 1,
+			wantErrs:  []string{"a.service"},
+		},
+		{
+			name:      "wrong substate for type",
+			specs:     []UnitSpec{{Name: "b.mount", SubStateDesired: "running"}},
+			wantUnits: 1,
+			wantErrs:  []string{"b.mount"},
+		},
+		{
+			name:      "unknown type",
+			specs:     []UnitSpec{{Name: "a.thing", SubStateDesired: "running"}},
+			wantUnits: 1,
+			wantErrs:  []string{"a.thing"},
+		},
+		{
+			name:      "bad unit file state",
+			specs:     []UnitSpec{{Name: "a.service", SubStateDesired: "running", UnitFileState: "on"}},
+			wantUnits: 1,
+			wantErrs:  []string{"a.service"},
+		},
+		{
+			name:      "not found",
+			specs:     []UnitSpec{{Name: "missing.service", SubStateDesired: "running"}},
+			wantUnits: 1,
+			wantErrs:  []string{"missing.service"},
+		},
+		{
+			name:      "masked",
+			specs:     []UnitSpec{{Name: "c.service", SubStateDesired: "running"}},
+			wantUnits: 1,
+			wantErrs:  []string{"c.service"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := len(loggedConfigErrors(t))
+			uw, _ := newTestWatcher(t, tt.specs,
+				FakeUnitSpec{Name: "a.service"},
+				FakeUnitSpec{Name: "b.mount"},
+				FakeUnitSpec{Name: "a.thing"},
+				FakeUnitSpec{Name: "c.service", LoadState: "masked"})
+
+			if len(uw.Units) != tt.wantUnits {
+				t.Errorf("watching %d units, want %d", len(uw.Units), tt.wantUnits)
+			}
+			errs := uw.ConfigErrors()
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("config errors = %v, want errors for %v", errs, tt.wantErrs)
+			}
+			for i, e := range errs {
+				if e.Unit != tt.wantErrs[i] {
+					t.Errorf("config error %d is for %s, want %s", i, e.Unit, tt.wantErrs[i])
+				}
+			}
+			if (uw.ConfigErrorBanner() == "") != (len(tt.wantErrs) == 0) {
+				t.Errorf("ConfigErrorBanner() = %q with %d errors", uw.ConfigErrorBanner(), len(errs))
+			}
+			// logged by the time the watcher is created
+			if got := loggedConfigErrors(t)[logged:]; !reflect.DeepEqual(got, append([]string{}, tt.wantErrs...)) {
+				t.Errorf("logged config errors for %v, want %v", got, tt.wantErrs)
+			}
+		})
+	}
+}
+
+// Units of the config errors in the log, in order.
+func loggedConfigErrors(t *testing.T) []string {
+	var buf bytes.Buffer
+	logging.Logs.WriteJSON(&buf)
+	var logs struct {
+		Events []struct {
+			ObjType string      `json:"objectType"`
+			Obj     ConfigError `json:"object"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &logs); err != nil {
+		t.Fatal(err)
+	}
+	units := make([]string, 0)
+	for _, e := range logs.Events {
+		if e.ObjType == "Config error" {
+			units = append(units, e.Obj.Unit)
+		}
+	}
+	return units
+}
+
+func TestPropertyHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		access   bool
+		length   int
+		denied   []string
+		unit     string
+		restarts int
+		want     []string // recorded changes, oldest first
+		wantErr  error
+	}{
+		{
+			name: "restarts", access: true, length: 100, unit: "a.service", restarts: 2,
+			want: []string{"NRestarts: 0", "NRestarts: 0 -> 1", "NRestarts: 1 -> 2"},
+		},
+		{
+			name: "bounded", access: true, length: 2, unit: "a.service", restarts: 3,
+			want: []string{"NRestarts: 1 -> 2", "NRestarts: 2 -> 3"},
+		},
+		{name: "no access", access: false, length: 100, unit: "a.service", restarts: 2, want: []string{}},
+		{name: "denied", access: true, length: 100, denied: []string{"NRestarts"}, unit: "a.service", restarts: 2, want: []string{}},
+		{name: "not watched", access: true, length: 100, unit: "b.service", wantErr: ErrUnitNotWatched},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set(t, &HISTORY_PROPERTIES, []string{"NRestarts"})
+			set(t, &HISTORY_LENGTH, tt.length)
+			set(t, &PROPERTY_DENYLIST, tt.denied)
+			set(t, &FLAP_THRESHOLD, 0)
+			set(t, &SYSTEMD_ACCESS, tt.access)
+			uw, fake := newTestWatcher(t,
+				[]UnitSpec{{Name: "a.service", SubStateDesired: "running"}},
+				FakeUnitSpec{Name: "a.service", ActiveState: "active", SubState: "running"})
+			for i := 0; i < tt.restarts; i++ {
+				fake.AddTransition(FakeTransition{Unit: "a.service", Restart: true})
+				uw.UpdateAll()
+			}
+
+			history, err := uw.PropertyHistory(tt.unit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PropertyHistory(%s) error = %v, want %v", tt.unit, err, tt.wantErr)
+			}
+			if len(history) != len(tt.want) {
+				t.Fatalf("PropertyHistory(%s) = %v, want %v", tt.unit, history, tt.want)
+			}
+			for i, c := range history {
+				// drop the time
+				if got := c.String()[len("15:04:05.000 "):]; got != tt.want[i] {
+					t.Errorf("change %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBootPhases(t *testing.T) {
+	uw, _ := newTestWatcher(t, nil)
+	uw.UpdateAll()
+
+	phases := uw.GetBootPhases()
+	names := make([]string, 0, len(phases))
+	for _, phase := range phases {
+		names = append(names, phase.Name)
+		if !phase.Finished || phase.Duration < 0 {
+			t.Errorf("phase %s finished %v after %s, want a finished phase", phase.Name, phase.Finished, phase.Duration)
+		}
+	}
+	if want := []string{"kernel", "userspace"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("boot phases = %v, want %v", names, want)
+	}
+	if !uw.bootFinished {
+		t.Errorf("boot not finished")
+	}
+}
+
+func TestBootPhasesRetried(t *testing.T) {
+	set(t, &UNIT_SPECS, nil)
+	backend := &failingManager{FakeSystemd: NewFakeSystemd(), err: errors.New("connection refused")}
+	uw, err := NewWatcher(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phases := uw.GetBootPhases(); len(phases) != 0 {
+		t.Fatalf("boot phases = %v while systemd is unreachable, want none", phases)
+	}
+
+	backend.err = nil
+	uw.UpdateAll()
+	phases := uw.GetBootPhases()
+	if len(phases) != 2 || phases[0].Duration <= 0 || !phases[1].Finished {
+		t.Errorf("boot phases = %+v after systemd is reachable again, want kernel and userspace", phases)
+	}
+}
+
+// Fails GetAllProperties with err while it is set.
+type failingBackend struct {
+	*FakeSystemd
+	err error
+}
+
+func (b *failingBackend) GetAllProperties(unit string) (map[string]interface{}, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.FakeSystemd.GetAllProperties(unit)
+}
+
+func TestReadErrorsLoggedOnChange(t *testing.T) {
+	uw, fake := newTestWatcher(t,
+		[]UnitSpec{{Name: "a.service", SubStateDesired: "running"}},
+		FakeUnitSpec{Name: "a.service", ActiveState: "active", SubState: "running"})
+	backend := &failingBackend{FakeSystemd: fake}
+	uw.DConn = backend
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	refused, reset := errors.New("connection refused"), errors.New("connection reset")
+	for _, err := range []error{refused, refused, refused, nil, refused, reset, reset} {
+		backend.err = err
+		uw.UpdateAll()
+	}
+
+	want := []string{"connection refused", "connection refused", "connection reset"}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q, want %v", lines, want)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d = %q, want %q", i, line, want[i])
+		}
+	}
+}
+
+// Fails the manager reads with err while it is set.
+type failingManager struct {
+	*FakeSystemd
+	err error
+}
+
+func (b *failingManager) GetManagerProperty(prop string) (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	return b.FakeSystemd.GetManagerProperty(prop)
+}
+
+func (b *failingManager) ListJobs() ([]dbus.JobStatus, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.FakeSystemd.ListJobs()
+}
+
+func (b *failingManager) ListUnitsFiltered(states []string) ([]dbus.UnitStatus, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.FakeSystemd.ListUnitsFiltered(states)
+}
+
+func TestManagerErrorsLoggedOnChange(t *testing.T) {
+	uw, fake := newTestWatcher(t, nil)
+	backend := &failingManager{FakeSystemd: fake}
+	uw.DConn = backend
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	refused, reset := errors.New("connection refused"), errors.New("connection reset")
+	for _, err := range []error{refused, refused, refused, nil, refused, reset, reset} {
+		backend.err = err
+		uw.UpdateAll()
+	}
+
+	want := []string{"connection refused", "connection refused", "connection reset"}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q, want %d lines", lines, len(want))
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, "Reading systemd manager status: "+want[i]) {
+			t.Errorf("line %d = %q, want the error %q", i, line, want[i])
+		}
+	}
+	if got := uw.GetManagerStatus().SystemState; got == "" {
+		t.Errorf("system state lost while systemd was unreachable")
+	}
+}
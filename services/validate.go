@@ -0,0 +1,152 @@
+// Checks of the unit specs in the config against systemd.
+package services
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"spirit-box/logging"
+	"strings"
+	"time"
+)
+
+// Substates systemd defines for each unit type.
+var validSubStates = map[string][]string{
+	".service": {"dead", "condition", "start-pre", "start", "start-post", "running", "exited", "reload",
+		"reload-signal", "reload-notify", "stop", "stop-watchdog", "stop-sigterm", "stop-sigkill", "stop-post",
+		"final-watchdog", "final-sigterm", "final-sigkill", "failed", "dead-before-auto-restart",
+		"failed-before-auto-restart", "dead-resources-pinned", "auto-restart", "auto-restart-queued", "cleaning"},
+	".socket": {"dead", "start-pre", "start-chown", "start-post", "listening", "running", "stop-pre",
+		"stop-pre-sigterm", "stop-pre-sigkill", "stop-post", "final-sigterm", "final-sigkill", "failed", "cleaning"},
+	".target": {"dead", "active"},
+	".device": {"dead", "tentative", "plugged"},
+	".mount": {"dead", "mounting", "mounting-done", "mounted", "remounting", "unmounting", "remounting-sigterm",
+		"remounting-sigkill", "unmounting-sigterm", "unmounting-sigkill", "failed", "cleaning"},
+	".automount": {"dead", "waiting", "running", "failed"},
+	".swap": {"dead", "activating", "activating-done", "active", "deactivating", "deactivating-sigterm",
+		"deactivating-sigkill", "failed", "cleaning"},
+	".timer": {"dead", "waiting", "running", "elapsed", "failed"},
+	".path":  {"dead", "waiting", "running", "failed"},
+	".slice": {"dead", "active"},
+	".scope": {"dead", "start-chown", "running", "abandoned", "stop-sigterm", "stop-sigkill", "failed"},
+}
+
+// A problem with a unit spec, found when the watcher starts.
+type ConfigError struct {
+	Unit    string `json:"unit"`
+	Problem string `json:"problem"`
+}
+
+func (c *ConfigError) LogLine() string {
+	return fmt.Sprintf("Config error for unit %s: %s", c.Unit, c.Problem)
+}
+
+func (c *ConfigError) GetObjType() string {
+	return "Config error"
+}
+
+// Returns the specs to watch, without duplicates, and the problems found with them.
+// Units that don't exist are still watched, since they may be loaded later on.
+func validateUnitSpecs(dConn SystemdBackend, specs []UnitSpec) ([]UnitSpec, []ConfigError) {
+	valid := make([]UnitSpec, 0, len(specs))
+	errs := make([]ConfigError, 0)
+	seen := make(map[string]bool)
+
+	for _, spec := range specs {
+		if seen[spec.Name] {
+			errs = append(errs, ConfigError{Unit: spec.Name, Problem: "Duplicate unit spec, only the first one is used."})
+			continue
+		}
+		seen[spec.Name] = true
+		valid = append(valid, spec)
+
+		if problem := checkSubState(spec); problem != "" {
+			errs = append(errs, ConfigError{Unit: spec.Name, Problem: problem})
+		}
+
+		if problem := checkUnitFileState(spec); problem != "" {
+			errs = append(errs, ConfigError{Unit: spec.Name, Problem: problem})
+		}
+
+		props, err := dConn.GetUnitProperties(spec.Name)
+		if err != nil {
+			log.Printf("Validating unit spec %s: %s", spec.Name, err.Error())
+			continue
+		}
+		switch loadState, _ := props["LoadState"].(string); loadState {
+		case "not-found":
+			errs = append(errs, ConfigError{Unit: spec.Name, Problem: "Unit does not exist."})
+		case "bad-setting", "error", "masked":
+			errs = append(errs, ConfigError{Unit: spec.Name, Problem: fmt.Sprintf("Unit can't be started, its load state is %s.", loadState)})
+		}
+	}
+
+	for i := range errs {
+		obj := &errs[i]
+		le := logging.NewLogEvent(obj.LogLine(), obj)
+		le.StartTime = time.Now()
+		le.EndTime = le.StartTime
+		logging.Logs.AddLogEvent(le)
+	}
+	return valid, errs
+}
+
+// Returns "" if the desired substate is valid for the unit's type.
+func checkSubState(spec UnitSpec) string {
+	if spec.SubStateDesired == "" {
+		return "No subStateDesired given."
+	}
+	if spec.SubStateDesired == "watch" {
+		return ""
+	}
+
+	unitType := filepath.Ext(spec.Name)
+	subStates, ok := validSubStates[unitType]
+	if !ok {
+		return fmt.Sprintf("Unknown unit type %q.", unitType)
+	}
+	for _, s := range subStates {
+		if s == spec.SubStateDesired {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s is not a substate of %s units, valid substates are: %s.",
+		spec.SubStateDesired, strings.TrimPrefix(unitType, "."), strings.Join(subStates, ", "))
+}
+
+// Returns "" if the expected unit file state is empty or one systemd knows.
+func checkUnitFileState(spec UnitSpec) string {
+	if spec.UnitFileState == "" {
+		return ""
+	}
+	for _, s := range validUnitFileStates {
+		if s == spec.UnitFileState {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s is not a unit file state, valid states are: %s.",
+		spec.UnitFileState, strings.Join(validUnitFileStates, ", "))
+}
+
+func (uw *UnitWatcher) ConfigErrors() []ConfigError {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+	return uw.configErrors
+}
+
+// Warning to show while there are config errors, "" if there are none.
+func (uw *UnitWatcher) ConfigErrorBanner() string {
+	errs := uw.ConfigErrors()
+	if len(errs) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("%d config errors:", len(errs)))
+	if len(errs) == 1 {
+		lines[0] = "1 config error:"
+	}
+	for _, e := range errs {
+		lines = append(lines, fmt.Sprintf("  %s: %s", e.Unit, e.Problem))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,119 @@
+// model for the boot analysis screen. Blame and critical chains of watched units.
+package analysis
+
+import (
+	"fmt"
+	"log"
+	"spirit-box/services"
+	g "spirit-box/tui/globals"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	lp "github.com/charmbracelet/lipgloss"
+)
+
+var headerStyle = lp.NewStyle().Bold(true)
+var alignRightStyle = lp.NewStyle().Align(lp.Right)
+
+type analysisMsg *services.BootAnalysis
+
+type Model struct {
+	Watcher  *services.UnitWatcher
+	viewport viewport.Model
+	analysis *services.BootAnalysis
+	running  bool
+	width    int
+	height   int
+}
+
+func New(watcher *services.UnitWatcher) Model {
+	return Model{
+		Watcher:  watcher,
+		viewport: viewport.New(150, 70),
+	}
+}
+
+// The analysis reads unit properties from systemd, so it runs outside of Update.
+func (m Model) analyze() tea.Msg {
+	return analysisMsg(m.Watcher.Analyze())
+}
+
+func (m *Model) render() {
+	var b strings.Builder
+
+	if m.analysis != nil {
+		fmt.Fprintf(&b, "%s\n", headerStyle.Render("Blame (time spent activating):"))
+		if len(m.analysis.Blame) == 0 {
+			fmt.Fprintf(&b, "No watched units have been activated.\n")
+		}
+		for _, t := range m.analysis.Blame {
+			fmt.Fprintf(&b, "%s %s\n", alignRight(12, fmt.Sprintf("%.3fs", t.Duration.Seconds())), t.Name)
+		}
+
+		fmt.Fprintf(&b, "\n%s\n", headerStyle.Render("Critical chains (@time after systemd start, +activation time):"))
+		for _, c := range m.analysis.CriticalChains {
+			fmt.Fprintf(&b, "\n%s", c.String())
+		}
+	}
+	if m.running {
+		fmt.Fprintf(&b, "\nAnalyzing...\n")
+	}
+
+	fmt.Fprintf(&b, "\nPress 'r' to refresh, 'q' to go back.\n")
+	m.viewport.SetContent(b.String())
+}
+
+func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case g.SwitchScreenMsg:
+		log.Printf("From analysis, SwitchScreenMsg: %s", g.Screen(msg).String())
+		if g.Screen(msg) == g.Analysis {
+			m.running = true
+			m.render()
+			return m, m.analyze
+		}
+		return m, nil
+	case analysisMsg:
+		m.analysis = msg
+		m.running = false
+		m.render()
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		if m.width == 0 {
+			m.width = 150
+		}
+		if m.height == 0 {
+			m.height = 70
+		}
+		m.viewport.Width, m.viewport.Height = m.width, m.height
+		return m, nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "r":
+			if m.running {
+				return m, nil
+			}
+			m.running = true
+			m.render()
+			return m, m.analyze
+		case "ctrl+c":
+			return m, tea.Quit
+		case "q":
+			return m, func() tea.Msg { return g.SwitchScreenMsg(g.TopLevel) }
+		}
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m Model) View() string {
+	return m.viewport.View()
+}
+
+func alignRight(width int, str string) string {
+	return alignRightStyle.Width(width).Render(str)
+}
@@ -17,6 +17,9 @@ const (
 	Systemd
 	UnitInfoScreen
 	Scripts
+	Analysis
+	Network
+	QRCode
 )
 
 func (s Screen) String() string {
@@ -29,6 +32,12 @@ func (s Screen) String() string {
 		return "UnitInfoScreen"
 	case Scripts:
 		return "Scripts"
+	case Analysis:
+		return "Analysis"
+	case Network:
+		return "Network"
+	case QRCode:
+		return "QRCode"
 	}
 	return "Unmapped enum value."
 }
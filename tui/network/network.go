@@ -0,0 +1,102 @@
+// model for the network diagnostics screen. Links, the default route and DNS.
+package network
+
+import (
+	"fmt"
+	"log"
+	"spirit-box/device"
+	g "spirit-box/tui/globals"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	lp "github.com/charmbracelet/lipgloss"
+)
+
+var headerStyle = lp.NewStyle().Bold(true)
+
+type diagnosticsMsg device.Diagnostics
+
+type Model struct {
+	viewport    viewport.Model
+	diagnostics *device.Diagnostics
+	running     bool
+	width       int
+	height      int
+}
+
+func New() Model {
+	return Model{
+		viewport: viewport.New(150, 70),
+	}
+}
+
+// The checks wait for the gateway and DNS, so they run outside of Update.
+func diagnose() tea.Msg {
+	return diagnosticsMsg(device.Diagnose())
+}
+
+func (m *Model) render() {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", headerStyle.Render("Network diagnostics"))
+	if m.diagnostics != nil {
+		fmt.Fprintf(&b, "as of %s\n\n", m.diagnostics.Time.Format("15:04:05"))
+		fmt.Fprintf(&b, "%s", m.diagnostics.String())
+	}
+	if m.running {
+		fmt.Fprintf(&b, "\nRunning checks...\n")
+	}
+	fmt.Fprintf(&b, "\nPress 'r' to rerun the checks, 'q' to go back.\n")
+	m.viewport.SetContent(b.String())
+}
+
+func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case g.SwitchScreenMsg:
+		log.Printf("From network, SwitchScreenMsg: %s", g.Screen(msg).String())
+		if g.Screen(msg) == g.Network {
+			m.running = true
+			m.render()
+			return m, diagnose
+		}
+		return m, nil
+	case diagnosticsMsg:
+		d := device.Diagnostics(msg)
+		m.diagnostics = &d
+		m.running = false
+		m.render()
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		if m.width == 0 {
+			m.width = 150
+		}
+		if m.height == 0 {
+			m.height = 70
+		}
+		m.viewport.Width, m.viewport.Height = m.width, m.height
+		return m, nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "r":
+			if m.running {
+				return m, nil
+			}
+			m.running = true
+			m.render()
+			return m, diagnose
+		case "ctrl+c":
+			return m, tea.Quit
+		case "q":
+			return m, func() tea.Msg { return g.SwitchScreenMsg(g.TopLevel) }
+		}
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m Model) View() string {
+	return m.viewport.View()
+}
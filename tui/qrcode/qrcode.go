@@ -0,0 +1,67 @@
+// model for the QR code screen. The dashboard's URL as a QR code, so it doesn't have to be typed.
+package qrcode
+
+import (
+	"fmt"
+	"log"
+	"spirit-box/device"
+	"spirit-box/qr"
+	g "spirit-box/tui/globals"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	lp "github.com/charmbracelet/lipgloss"
+)
+
+var headerStyle = lp.NewStyle().Bold(true)
+
+type Model struct {
+	url  string
+	code string
+}
+
+func New() Model {
+	m := Model{}
+	m.refresh()
+	return m
+}
+
+func (m *Model) refresh() {
+	m.url, m.code = device.DashboardURL(), ""
+	if m.url == "" {
+		return
+	}
+	code, err := qr.Encode(m.url)
+	if err != nil {
+		log.Printf("Encoding %s as a QR code: %s", m.url, err.Error())
+		return
+	}
+	m.code = code.HalfBlocks()
+}
+
+func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case g.UpdateIPsMsg:
+		m.refresh()
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "q":
+			return m, func() tea.Msg { return g.SwitchScreenMsg(g.TopLevel) }
+		}
+	}
+	return m, nil
+}
+
+func (m Model) View() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", headerStyle.Render("Dashboard"))
+	if m.url == "" {
+		fmt.Fprintf(&b, "Waiting for an address.\n")
+	} else {
+		fmt.Fprintf(&b, "%s\n%s\n", m.code, m.url)
+	}
+	fmt.Fprintf(&b, "\nPress 'q' to go back.\n")
+	return b.String()
+}
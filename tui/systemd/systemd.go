@@ -12,7 +12,6 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	lp "github.com/charmbracelet/lipgloss"
-	"github.com/coreos/go-systemd/v22/dbus"
 	//	"log"
 )
 
@@ -31,20 +30,21 @@ type Model struct {
 	textinputSelected bool
 	AllReady          bool
 	/*
-		The two fields below are used when adding new units
+		The fields below are used when adding or removing units
 		while the program is running.
 	*/
 	addUnitBeforeUpdate bool
 	newUnitName         string
+	watchStatus         string // result of the last addition or removal
 	width               int
 	height              int
 }
 
-func New(dConn *dbus.Conn, Watcher *services.UnitWatcher) Model {
+func New(dConn services.SystemdBackend, Watcher *services.UnitWatcher) Model {
 	s := spinner.New()
 	s.Spinner = spinner.Line
 	t := textinput.New()
-	t.Placeholder = "Press \"/\" to add more units to watch"
+	t.Placeholder = "Press \"/\" to add more units to watch, \"d\" to stop watching the selected unit"
 	return Model{
 		Watcher:     Watcher,
 		curScreen:   g.Systemd,
@@ -93,9 +93,30 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 						m.cursorIndex--
 					}
 				case "enter":
-					m.unitInfo = InitUnitInfo(m.Watcher.Units[m.cursorIndex].Properties, m.width, m.height)
+					if m.cursorIndex >= len(m.Watcher.Units) {
+						break
+					}
+					unit := m.Watcher.Units[m.cursorIndex]
+					journal, err := m.Watcher.RecentJournal(unit.Name)
+					if err != nil {
+						log.Print(err)
+					}
+					m.unitInfo = InitUnitInfo(m.Watcher, unit, journal, m.width, m.height)
 					cmd := func() tea.Msg { return g.SwitchScreenMsg(g.UnitInfoScreen) }
 					cmds = append(cmds, cmd)
+				case "d":
+					if m.cursorIndex >= len(m.Watcher.Units) {
+						break
+					}
+					name := m.Watcher.Units[m.cursorIndex].Name
+					if err := m.Watcher.RemoveUnit(name); err != nil {
+						m.watchStatus = fmt.Sprintf("Removing %s: %s", name, err.Error())
+					} else {
+						m.watchStatus = fmt.Sprintf("Stopped watching %s.", name)
+					}
+					if m.cursorIndex > 0 && m.cursorIndex >= len(m.Watcher.Units) {
+						m.cursorIndex--
+					}
 				case "/":
 					m.textinputSelected = true
 					m.textinput.Focus()
@@ -117,7 +138,11 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case g.CheckSystemdMsg:
 		if m.addUnitBeforeUpdate {
-			m.Watcher.AddUnit(m.newUnitName)
+			if err := m.Watcher.AddUnit(m.newUnitName); err != nil {
+				m.watchStatus = err.Error()
+			} else {
+				m.watchStatus = fmt.Sprintf("Started watching %s.", m.newUnitName)
+			}
 			m.addUnitBeforeUpdate = false
 		}
 		m.AllReady = m.Watcher.UpdateAll()
@@ -154,6 +179,12 @@ func (m Model) View() string {
 		} else {
 			info = notReadyStyle.Render(m.spinner.View())
 		}
+		if banner := m.Watcher.ConnectionBanner(); banner != "" {
+			fmt.Fprintf(&b, "%s\n", notReadyStyle.Render(banner))
+		}
+		if banner := m.Watcher.ConfigErrorBanner(); banner != "" {
+			fmt.Fprintf(&b, "%s\n", notReadyStyle.Render(banner))
+		}
 		fmt.Fprintf(&b, "Watching %d services (%.0fs): %s\n\n",
 			m.Watcher.NumUnits(),
 			m.Watcher.Elapsed().Seconds(),
@@ -162,7 +193,11 @@ func (m Model) View() string {
 
 		var readyStatus string
 		for i, u := range m.Watcher.Units {
-			if u.SubStateDesired == "watch" {
+			if u.Flapping {
+				readyStatus = notReadyStyle.Render("FLAPPING")
+			} else if u.FileStateDrift {
+				readyStatus = notReadyStyle.Render("DRIFT")
+			} else if u.SubStateDesired == "watch" {
 				readyStatus = readyStyle.Render("WATCHING")
 			} else if u.Ready {
 				readyStatus = readyStyle.Render("READY")
@@ -182,9 +217,15 @@ func (m Model) View() string {
 			}
 
 			fmt.Fprintf(&b, "%s%s %s\n", left, alignRight(80-len(left), right), u.Description)
+			if details := u.Details.Summary(); details != "" {
+				fmt.Fprintf(&b, "    %s\n", details)
+			}
 		}
 
 		fmt.Fprintf(&b, "\n%s", m.textinput.View())
+		if m.watchStatus != "" {
+			fmt.Fprintf(&b, "\n%s", m.watchStatus)
+		}
 
 		return b.String()
 	case g.UnitInfoScreen:
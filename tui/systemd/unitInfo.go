@@ -4,6 +4,7 @@ package systemd
 import (
 	"fmt"
 	"sort"
+	"spirit-box/services"
 	g "spirit-box/tui/globals"
 	"strings"
 
@@ -12,12 +13,55 @@ import (
 )
 
 type unitInfo struct {
-	name     string
-	viewport viewport.Model
+	name         string
+	watcher      *services.UnitWatcher
+	viewport     viewport.Model
+	actionStatus string
 }
 
-func InitUnitInfo(properties map[string]interface{}, width, height int) unitInfo {
-	u := unitInfo{}
+// Keys for the actions that can be run on the unit.
+var actionKeys = map[string]string{
+	"s": "start",
+	"x": "stop",
+	"r": "restart",
+	"f": "reset-failed",
+}
+
+type unitActionMsg struct {
+	name   string
+	action string
+	result string
+	err    error
+}
+
+func InitUnitInfo(watcher *services.UnitWatcher, unit *services.UnitInfo, journal []services.JournalEntry, width, height int) unitInfo {
+	u := unitInfo{name: unit.Name, watcher: watcher}
+	properties := unit.Properties
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s %s %s\n", unit.Name, unit.LoadState, unit.ActiveState, unit.SubState)
+	if unit.UnitFileStateDesired != "" {
+		fmt.Fprintf(&b, "Unit file state: %s, expected %s\n", unit.UnitFileState, unit.UnitFileStateDesired)
+	}
+	if unit.StatusText != "" {
+		fmt.Fprintf(&b, "Status: %s\n", unit.StatusText)
+	}
+	if details := unit.Details.Summary(); details != "" {
+		fmt.Fprintf(&b, "Details: %s\n", details)
+	}
+	if len(journal) > 0 {
+		fmt.Fprintf(&b, "\nRecent journal lines:\n")
+		for _, entry := range journal {
+			fmt.Fprintf(&b, "  %s\n", entry.String())
+		}
+	}
+	if history, err := watcher.PropertyHistory(unit.Name); err == nil && len(history) > 0 {
+		fmt.Fprintf(&b, "\nProperty history:\n")
+		for _, change := range history {
+			fmt.Fprintf(&b, "  %s\n", change.String())
+		}
+	}
+	fmt.Fprintf(&b, "\n")
 
 	keys := make([]string, len(properties))
 	i := 0
@@ -29,7 +73,6 @@ func InitUnitInfo(properties map[string]interface{}, width, height int) unitInfo
 		return keys[i] < keys[j]
 	})
 
-	var b strings.Builder
 	for _, key := range keys {
 		v := properties[key]
 		fmt.Fprintf(&b, "%s: %v\n", key, v)
@@ -47,6 +90,20 @@ func (u unitInfo) Update(msg tea.Msg) (unitInfo, tea.Cmd) {
 		case "q":
 			return u, func() tea.Msg { return g.SwitchScreenMsg(g.Systemd) }
 		}
+		if action, ok := actionKeys[msg.String()]; ok && services.UNIT_ACTIONS {
+			u.actionStatus = fmt.Sprintf("Running %s...", action)
+			return u, runAction(u.watcher, u.name, action)
+		}
+	case unitActionMsg:
+		if msg.name != u.name {
+			return u, nil
+		}
+		if msg.err != nil {
+			u.actionStatus = fmt.Sprintf("%s failed: %s", msg.action, msg.err.Error())
+		} else {
+			u.actionStatus = fmt.Sprintf("%s: %s", msg.action, msg.result)
+		}
+		return u, nil
 	}
 
 	var cmd tea.Cmd
@@ -55,5 +112,20 @@ func (u unitInfo) Update(msg tea.Msg) (unitInfo, tea.Cmd) {
 }
 
 func (u unitInfo) View() string {
-	return u.viewport.View()
+	if !services.UNIT_ACTIONS {
+		return u.viewport.View()
+	}
+	help := "Actions: 's' start, 'x' stop, 'r' restart, 'f' reset-failed"
+	if u.actionStatus != "" {
+		help += "\n" + u.actionStatus
+	}
+	return help + "\n\n" + u.viewport.View()
+}
+
+// Actions wait for their job to finish, so they run outside of the update loop.
+func runAction(watcher *services.UnitWatcher, name, action string) tea.Cmd {
+	return func() tea.Msg {
+		result, err := watcher.RunAction(name, action)
+		return unitActionMsg{name: name, action: action, result: result, err: err}
+	}
 }
@@ -9,14 +9,16 @@ import (
 	"spirit-box/device"
 	"spirit-box/scripts"
 	"spirit-box/services"
+	"spirit-box/tui/analysis"
 	g "spirit-box/tui/globals"
+	"spirit-box/tui/network"
+	"spirit-box/tui/qrcode"
 	"spirit-box/tui/scriptsTui"
 	"spirit-box/tui/systemd"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	lp "github.com/charmbracelet/lipgloss"
-	"github.com/coreos/go-systemd/v22/dbus"
 )
 
 var readyStyle = lp.NewStyle().Bold(true).Foreground(lp.Color("10"))
@@ -28,6 +30,8 @@ const (
 	height = 100
 	vPos   = height / 2
 	hPos   = width / 2
+
+	maxJobs = 5 // pending systemd jobs shown in the header
 )
 
 type model struct {
@@ -36,6 +40,9 @@ type model struct {
 	curScreen   g.Screen
 	systemd     systemd.Model
 	scripts     scriptsTui.Model
+	analysis    analysis.Model
+	network     network.Model
+	qrcode      qrcode.Model
 	ipStr       string
 	spinner     spinner.Model
 	wipe        bool
@@ -70,10 +77,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmds = append(cmds, cmd)
 	case g.UpdateIPsMsg:
 		m.ipStr = device.CreateIPStr()
+		m.qrcode, cmd = m.qrcode.Update(msg)
+		cmds = append(cmds, cmd)
 		return m, tea.Batch(cmds...)
 	case tea.WindowSizeMsg:
 		m.systemd, cmd = m.systemd.Update(msg)
 		cmds = append(cmds, cmd)
+		m.analysis, cmd = m.analysis.Update(msg)
+		cmds = append(cmds, cmd)
+		m.network, cmd = m.network.Update(msg)
+		cmds = append(cmds, cmd)
 	case spinner.TickMsg:
 		m.systemd, cmd = m.systemd.Update(msg)
 		cmds = append(cmds, cmd)
@@ -97,6 +110,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, func() tea.Msg { return g.SwitchScreenMsg(g.Systemd) }
 				} else if m.cursorIndex == 1 {
 					return m, func() tea.Msg { return g.SwitchScreenMsg(g.Scripts) }
+				} else if m.cursorIndex == 2 {
+					return m, func() tea.Msg { return g.SwitchScreenMsg(g.Analysis) }
+				} else if m.cursorIndex == 3 {
+					return m, func() tea.Msg { return g.SwitchScreenMsg(g.Network) }
+				} else if m.cursorIndex == 4 {
+					return m, func() tea.Msg { return g.SwitchScreenMsg(g.QRCode) }
 				}
 			case "q":
 				return m, tea.Quit
@@ -114,6 +133,15 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case g.UnitInfoScreen:
 		m.systemd, cmd = m.systemd.Update(msg)
 		cmds = append(cmds, cmd)
+	case g.Analysis:
+		m.analysis, cmd = m.analysis.Update(msg)
+		cmds = append(cmds, cmd)
+	case g.Network:
+		m.network, cmd = m.network.Update(msg)
+		cmds = append(cmds, cmd)
+	case g.QRCode:
+		m.qrcode, cmd = m.qrcode.Update(msg)
+		cmds = append(cmds, cmd)
 	}
 
 	return m, tea.Batch(cmds...)
@@ -129,6 +157,12 @@ func (m model) View() string {
 		var b strings.Builder
 		var info string
 		fmt.Fprintf(&b, "spirit-box\n")
+		if banner := m.systemd.Watcher.ConnectionBanner(); banner != "" {
+			fmt.Fprintf(&b, "%s\n", notReadyStyle.Render(banner))
+		}
+		if banner := m.systemd.Watcher.ConfigErrorBanner(); banner != "" {
+			fmt.Fprintf(&b, "%s\n", notReadyStyle.Render(banner))
+		}
 
 		systemdReady := m.systemd.AllReady
 		scriptsReady := m.scripts.AllReady
@@ -150,11 +184,17 @@ func (m model) View() string {
 			fmt.Fprintf(&b, readyStyle.Render("\n\nSystem is ready. Press 'q' to close spirit-box."))
 		}
 
-		fmt.Fprintf(&b, fmt.Sprintf("\n\n%s\n\n", m.ipStr))
+		fmt.Fprintf(&b, fmt.Sprintf("\n\n%s\n", m.ipStr))
+		fmt.Fprintf(&b, "%s\n", m.systemd.Watcher.BootPhaseSummary())
+		fmt.Fprintf(&b, "%s\n\n", m.systemd.Watcher.GetManagerStatus().Header(maxJobs))
 
 		var readyStatus string
 		for _, u := range m.systemd.Watcher.Units {
-			if u.Ready {
+			if u.Flapping {
+				readyStatus = notReadyStyle.Render("FLAPPING")
+			} else if u.FileStateDrift {
+				readyStatus = notReadyStyle.Render("DRIFT")
+			} else if u.Ready {
 				readyStatus = readyStyle.Render("READY")
 			} else {
 				readyStatus = notReadyStyle.Render(m.spinner.View())
@@ -193,6 +233,12 @@ func (m model) View() string {
 		view = m.scripts.View()
 	case g.UnitInfoScreen:
 		view = m.systemd.View()
+	case g.Analysis:
+		view = m.analysis.View()
+	case g.Network:
+		view = m.network.View()
+	case g.QRCode:
+		view = m.qrcode.View()
 	default:
 		view = "Something went wrong!"
 	}
@@ -204,7 +250,7 @@ func alignRight(width int, str string) string {
 	return alignRightStyle.Width(width).Render(str)
 }
 
-func initialModel(dConn *dbus.Conn, watcher *services.UnitWatcher, sc *scripts.ScriptController) model {
+func initialModel(dConn services.SystemdBackend, watcher *services.UnitWatcher, sc *scripts.ScriptController) model {
 	s := spinner.New()
 	s.Spinner = spinner.Line
 	whitespace := ""
@@ -212,18 +258,21 @@ func initialModel(dConn *dbus.Conn, watcher *services.UnitWatcher, sc *scripts.S
 		whitespace += "\n"
 	}
 	return model{
-		options:     []string{"systemd", "scripts"},
+		options:     []string{"systemd", "scripts", "boot analysis", "network", "qr code"},
 		cursorIndex: 0,
 		curScreen:   g.TopLevel,
 		systemd:     systemd.New(dConn, watcher),
 		scripts:     scriptsTui.New(sc),
+		analysis:    analysis.New(watcher),
+		network:     network.New(),
+		qrcode:      qrcode.New(),
 		ipStr:       device.CreateIPStr(),
 		spinner:     s,
 		whitespace:  whitespace,
 	}
 }
 
-func CreateProgram(dConn *dbus.Conn, watcher *services.UnitWatcher, sc *scripts.ScriptController) *tea.Program {
+func CreateProgram(dConn services.SystemdBackend, watcher *services.UnitWatcher, sc *scripts.ScriptController) *tea.Program {
 	model := initialModel(dConn, watcher, sc)
 	p := tea.NewProgram(model, tea.WithAltScreen())
 	// update ticker
@@ -240,10 +289,13 @@ func CreateProgram(dConn *dbus.Conn, watcher *services.UnitWatcher, sc *scripts.
 			time.Sleep(time.Duration(1250) * time.Millisecond)
 		}
 	}(p)
+	// pushed by the address monitor
 	go func(p *tea.Program) {
+		changed := device.AddrsChanged()
 		for {
+			<-changed
+			changed = device.AddrsChanged()
 			p.Send(g.UpdateIPsMsg(struct{}{}))
-			time.Sleep(3*time.Second)
 		}
 	}(p)
 	return p
@@ -8,6 +8,7 @@ import (
 
 	"spirit-box/config"
 	"spirit-box/device"
+	"spirit-box/qr"
 	"spirit-box/scripts"
 	"spirit-box/services"
 	"spirit-box/styles"
@@ -16,7 +17,6 @@ import (
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	lp "github.com/charmbracelet/lipgloss"
-	"github.com/coreos/go-systemd/v22/dbus"
 )
 
 var readyStyle = lp.NewStyle().Bold(true).Foreground(lp.Color("10"))
@@ -28,15 +28,44 @@ const (
 	height = 100
 	vPos   = height / 2
 	hPos   = width / 2
+
+	maxJobs = 5 // pending systemd jobs shown in the header
 )
 
 type model struct {
-	watcher    *services.UnitWatcher
-	controller *scripts.ScriptController
-	ipStr      string
-	spinner    spinner.Model
-	wipe       bool
-	whitespace string
+	watcher     *services.UnitWatcher
+	controller  *scripts.ScriptController
+	ipStr       string
+	spinner     spinner.Model
+	wipe        bool
+	whitespace  string
+	showNetwork bool // network diagnostics instead of units and scripts
+	network     *device.Diagnostics
+	diagnosing  bool
+	showQRCode  bool // the dashboard's URL as a QR code instead of units and scripts
+	url         string
+	qrCode      string
+}
+
+// Plain ASCII, the consoles tui_lite runs on often can't draw the half blocks.
+func (m *model) updateQRCode() {
+	m.url, m.qrCode = device.DashboardURL(), ""
+	if m.url == "" {
+		return
+	}
+	code, err := qr.Encode(m.url)
+	if err != nil {
+		log.Printf("Encoding %s as a QR code: %s", m.url, err.Error())
+		return
+	}
+	m.qrCode = code.ASCII()
+}
+
+type diagnosticsMsg device.Diagnostics
+
+// The checks wait for the gateway and DNS, so they run outside of Update.
+func diagnose() tea.Msg {
+	return diagnosticsMsg(device.Diagnose())
 }
 
 func (m model) Init() tea.Cmd {
@@ -60,9 +89,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, tea.Batch(cmds...)
 	case g.UpdateIPsMsg:
 		m.ipStr = device.CreateIPStr()
+		m.updateQRCode()
+		return m, tea.Batch(cmds...)
+	case diagnosticsMsg:
+		d := device.Diagnostics(msg)
+		m.network = &d
+		m.diagnosing = false
 		return m, tea.Batch(cmds...)
 	case tea.KeyMsg:
 		switch msg.String() {
+		case "c":
+			m.showQRCode = !m.showQRCode
+			m.showNetwork = false
+			return m, tea.Batch(cmds...)
+		case "n":
+			m.showNetwork = !m.showNetwork
+			m.showQRCode = false
+			if m.showNetwork && !m.diagnosing {
+				m.diagnosing = true
+				cmds = append(cmds, diagnose)
+			}
+			return m, tea.Batch(cmds...)
 		case "r":
 			cmds = append(cmds, func() tea.Msg {
 				return g.WipeScreenMsg(struct{}{})
@@ -87,10 +134,32 @@ func (m model) View() string {
 	header, allReady := m.StatusHeader()
 	fmt.Fprintf(&b, lp.JoinHorizontal(lp.Top, styles.DoubleBorder.Render("spirit-box"), header))
 	fmt.Fprintf(&b, "\n")
+	if m.showQRCode {
+		if m.url == "" {
+			fmt.Fprintf(&b, "\nWaiting for an address.\n")
+		} else {
+			fmt.Fprintf(&b, "\n%s\n%s\n", m.qrCode, m.url)
+		}
+		fmt.Fprintf(&b, "\nPress 'c' to go back, 'r' to manually re-render the screen.\n")
+		return lp.PlaceHorizontal(width, 0, b.String())
+	}
+
+	if m.showNetwork {
+		fmt.Fprintf(&b, "\nNetwork:\n")
+		if m.network != nil {
+			fmt.Fprintf(&b, "%s", m.network.String())
+		}
+		if m.diagnosing {
+			fmt.Fprintf(&b, "Running checks...\n")
+		}
+		fmt.Fprintf(&b, "\nPress 'n' to go back, 'r' to manually re-render the screen.\n")
+		return lp.PlaceHorizontal(width, 0, b.String())
+	}
+
 	if allReady && config.BANNER_MESSAGE != "" {
 		log.Printf(config.BANNER_MESSAGE)
 		fmt.Fprintf(&b, lp.PlaceHorizontal(100, 0.0, styles.DoubleBorderPadded.Render(config.BANNER_MESSAGE)))
-		fmt.Fprintf(&b, "\n\nPress 'r' to manually re-render the screen.\n")
+		fmt.Fprintf(&b, "\n\nPress 'r' to manually re-render the screen, 'n' for network diagnostics, 'c' for a QR code of the dashboard's URL.\n")
 		return lp.PlaceHorizontal(width, 0, b.String())
 	}
 
@@ -104,7 +173,11 @@ func (m model) View() string {
 			displayName = u.Name
 		}
 
-		if u.Ready {
+		if u.Flapping {
+			readyStatus = notReadyStyle.Render(fmt.Sprintf("FLAPPING (%d restarts)", u.NRestarts))
+		} else if u.FileStateDrift {
+			readyStatus = notReadyStyle.Render(fmt.Sprintf("DRIFT (%s, expected %s)", u.UnitFileState, u.UnitFileStateDesired))
+		} else if u.Ready {
 			readyStatus = readyStyle.Render("READY")
 		} else {
 			readyStatus = notReadyStyle.Render(m.spinner.View())
@@ -112,6 +185,9 @@ func (m model) View() string {
 
 		left := displayName + ":"
 		fmt.Fprintf(&b, "%s%s\n", left, alignRight(100-len(left), readyStatus))
+		if details := u.Details.Summary(); details != "" {
+			fmt.Fprintf(&b, "    %s\n", details)
+		}
 	}
 
 	fmt.Fprintf(&b, "\nScripts:\n")
@@ -135,7 +211,7 @@ func (m model) View() string {
 		fmt.Fprintf(&b, "%s%s\n", displayName, alignRight(100-len(displayName), readyStatus))
 	}
 
-	fmt.Fprintf(&b, "\nPress 'r' to manually re-render the screen.\n")
+	fmt.Fprintf(&b, "\nPress 'r' to manually re-render the screen, 'n' for network diagnostics, 'c' for a QR code of the dashboard's URL.\n")
 
 	return lp.PlaceHorizontal(width, 0, b.String())
 }
@@ -145,6 +221,13 @@ func (m model) StatusHeader() (string, bool) {
 	var info string
 	var allReady bool
 
+	if banner := m.watcher.ConnectionBanner(); banner != "" {
+		fmt.Fprintf(&b, "%s\n", notReadyStyle.Render(banner))
+	}
+	if banner := m.watcher.ConfigErrorBanner(); banner != "" {
+		fmt.Fprintf(&b, "%s\n", notReadyStyle.Render(banner))
+	}
+
 	unitsRemaining := m.watcher.NumUnitsNotReady()
 	scriptsRemaining, scriptsFailed := m.controller.GetStatus()
 	if unitsRemaining == 0 {
@@ -174,6 +257,8 @@ func (m model) StatusHeader() (string, bool) {
 	}
 
 	fmt.Fprintf(&b, fmt.Sprintf("\n%s\n", m.ipStr))
+	fmt.Fprintf(&b, "%s\n", m.watcher.BootPhaseSummary())
+	fmt.Fprintf(&b, "%s\n", m.watcher.GetManagerStatus().Header(maxJobs))
 
 	return styles.LeftPadding.Render(b.String()), allReady
 }
@@ -182,23 +267,25 @@ func alignRight(width int, str string) string {
 	return alignRightStyle.Width(width).Render(str)
 }
 
-func initialModel(dConn *dbus.Conn, watcher *services.UnitWatcher, sc *scripts.ScriptController) model {
+func initialModel(dConn services.SystemdBackend, watcher *services.UnitWatcher, sc *scripts.ScriptController) model {
 	s := spinner.New()
 	s.Spinner = spinner.Line
 	whitespace := ""
 	for i := 0; i < height; i++ {
 		whitespace += "\n"
 	}
-	return model{
+	m := model{
 		watcher:    watcher,
 		controller: sc,
 		ipStr:      device.CreateIPStr(),
 		spinner:    s,
 		whitespace: whitespace,
 	}
+	m.updateQRCode()
+	return m
 }
 
-func CreateProgram(dConn *dbus.Conn, watcher *services.UnitWatcher, sc *scripts.ScriptController) *tea.Program {
+func CreateProgram(dConn services.SystemdBackend, watcher *services.UnitWatcher, sc *scripts.ScriptController) *tea.Program {
 	model := initialModel(dConn, watcher, sc)
 	p := tea.NewProgram(model, tea.WithAltScreen())
 
@@ -209,10 +296,13 @@ func CreateProgram(dConn *dbus.Conn, watcher *services.UnitWatcher, sc *scripts.
 			time.Sleep(time.Second)
 		}
 	}(p)
+	// pushed by the address monitor
 	go func(p *tea.Program) {
+		changed := device.AddrsChanged()
 		for {
+			<-changed
+			changed = device.AddrsChanged()
 			p.Send(g.UpdateIPsMsg(struct{}{}))
-			time.Sleep(3*time.Second)
 		}
 	}(p)
 	return p
@@ -0,0 +1,51 @@
+import React, { useState, useEffect } from "react";
+import './App.css';
+
+const Addresses = () => {
+	const addressesEndpoint = `http://${window.location.hostname}:${window.location.port}/addresses`;
+	const [nics, setNics] = useState([]);
+
+	useEffect(() => {
+		let stopped = false;
+		let timeout = null;
+		// the server holds each request until an address changes
+		const update = (wait) => {
+			fetch(`${addressesEndpoint}?wait=${wait}`)
+			.then(res => res.json())
+			.then(data => {
+				if (!stopped) {
+					setNics(data);
+					update(25);
+				}
+			})
+			.catch((err) => {
+				if (!stopped) {
+					setNics([]);
+					timeout = setTimeout(() => update(0), 3000);
+				}
+			});
+		};
+		update(0);
+		return () => {
+			stopped = true;
+			clearTimeout(timeout);
+		};
+	}, [addressesEndpoint]);
+
+	if (nics === null || nics.length === 0) {
+		return null;
+	}
+	return (
+		<div className="mb-5">
+			{nics.map((nic) => (
+				<div key={nic.nic}>
+					<span className="font-bold">{nic.nic}:</span>
+					{" IPv4 "}{nic.ipv4.length > 0 ? nic.ipv4.join(", ") : "none"}
+					{" IPv6 "}{nic.ipv6.length > 0 ? nic.ipv6.join(", ") : "none"}
+				</div>
+			))}
+		</div>
+	);
+};
+
+export default Addresses;
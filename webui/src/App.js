@@ -3,6 +3,10 @@ import ScriptsDashboard from "./ScriptsDashboard.js";
 import UnitDashboard from "./UnitDashboard.js";
 import UnitInfo from "./UnitInfo.js";
 import TrackerInfo from "./TrackerInfo.js";
+import BootPhases from "./BootPhases.js";
+import ConnectionBanner from "./ConnectionBanner.js";
+import ConfigErrors from "./ConfigErrors.js";
+import Addresses from "./Addresses.js";
 import './App.css';
 
 function App() {
@@ -46,7 +50,7 @@ function App() {
 
 	const handleUnitInfo = (unit) => {
 		return (e) => {
-			setUnitInfo(unit.Properties);
+			setUnitInfo(unit);
 			setUnitInfoOpen(true);
 		};
 	};
@@ -59,7 +63,7 @@ function App() {
 	};
 
 	if (unitInfoOpen) {
-		return <UnitInfo unitInfo={unitInfo} close={() => setUnitInfoOpen(false)} />;
+		return <UnitInfo unit={unitInfo} close={() => setUnitInfoOpen(false)} />;
 	} else if (trackerInfoOpen) {
 		return <TrackerInfo tracker={trackerInfo} close={() => setTrackerInfoOpen(false)}/>;
 	} else if (hostIsUp) {
@@ -88,6 +92,10 @@ function App() {
 				spirit-box
 			</h1>
 
+			<Addresses />
+			<ConnectionBanner />
+			<ConfigErrors />
+			<BootPhases />
 			<ScriptsDashboard handleTrackerInfo={handleTrackerInfo}/>
 			<UnitDashboard handleUnitInfo={handleUnitInfo} />
 
@@ -0,0 +1,57 @@
+import React, { useState, useEffect } from "react";
+import './App.css';
+
+const BootPhases = () => {
+	const bootEndpoint = `http://${window.location.hostname}:${window.location.port}/boot`;
+	const [phases, setPhases] = useState([]);
+
+	useEffect(() => {
+		const update = () => {
+			fetch(bootEndpoint)
+			.then(res => res.json())
+			.then(data => setPhases(data))
+			.catch((err) => setPhases([]));
+		};
+		update();
+		const interval = setInterval(update, 1000);
+		return () => clearInterval(interval);
+	}, [bootEndpoint]);
+
+	// durations are in nanoseconds
+	const formatDuration = (duration) => `${(duration / 1e9).toFixed(3)}s`;
+
+	if (phases === null || phases.length === 0) {
+		return null;
+	}
+
+	const total = phases.reduce((sum, phase) => sum + phase.duration, 0);
+	const finished = phases.every(phase => phase.finished);
+
+	return (
+		<div className="w-full mb-10 text-sm">
+		<div className="font-bold mb-3">
+			{finished ? `Boot took ${formatDuration(total)}.` : "Boot is in progress..."}
+		</div>
+		<table className="table-auto text-left rounded shadow-xl">
+			<thead>
+			<tr className="tableHeaderRow">
+				{phases.map(phase => (
+					<th key={phase.name} className="pr-5">{phase.name}</th>
+				))}
+			</tr>
+			</thead>
+			<tbody>
+			<tr>
+				{phases.map(phase => (
+					<td key={phase.name} className="pr-5">
+						{phase.finished ? formatDuration(phase.duration) : "in progress"}
+					</td>
+				))}
+			</tr>
+			</tbody>
+		</table>
+		</div>
+	);
+};
+
+export default BootPhases;
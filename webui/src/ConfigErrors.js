@@ -0,0 +1,29 @@
+import React, { useState, useEffect } from "react";
+import './App.css';
+
+const ConfigErrors = () => {
+	const configErrorsEndpoint = `http://${window.location.hostname}:${window.location.port}/config/errors`;
+	const [errors, setErrors] = useState([]);
+
+	useEffect(() => {
+		// unit specs are only validated at startup
+		fetch(configErrorsEndpoint)
+		.then(res => res.json())
+		.then(data => setErrors(data))
+		.catch((err) => setErrors([]));
+	}, [configErrorsEndpoint]);
+
+	if (errors.length === 0) {
+		return null;
+	}
+	return (
+		<div className="rounded-sm bg-rose-500 p-2 mb-5 shadow-xl">
+			<div className="font-bold">Config errors</div>
+			{errors.map((e) => (
+				<div key={e.unit + e.problem}>{e.unit}: {e.problem}</div>
+			))}
+		</div>
+	);
+};
+
+export default ConfigErrors;
@@ -0,0 +1,28 @@
+import React, { useState, useEffect } from "react";
+import './App.css';
+
+const ConnectionBanner = () => {
+	const connectionEndpoint = `http://${window.location.hostname}:${window.location.port}/connection`;
+	const [banner, setBanner] = useState("");
+
+	useEffect(() => {
+		const interval = setInterval(() => {
+			fetch(connectionEndpoint)
+			.then(res => res.json())
+			.then(data => setBanner(data.banner))
+			.catch((err) => setBanner(""));
+		}, 1000);
+		return () => clearInterval(interval);
+	}, [connectionEndpoint]);
+
+	if (banner === "") {
+		return null;
+	}
+	return (
+		<div className="font-bold rounded-sm bg-rose-500 p-2 mb-5 shadow-xl">
+			{banner}
+		</div>
+	);
+};
+
+export default ConnectionBanner;
@@ -38,6 +38,14 @@ const UnitDashboard = ({ handleUnitInfo }) => {
 		if (unit.SubStateDesired === "watch") {
 			s = "WATCHING";
 		}
+		if (unit.FileStateDrift) {
+			s = `DRIFT (${unit.UnitFileState}, expected ${unit.UnitFileStateDesired})`;
+			style = "notReady";
+		}
+		if (unit.Flapping) {
+			s = "FLAPPING";
+			style = "notReady";
+		}
 
 		return (
 			<td className={style}>
@@ -95,6 +103,7 @@ const UnitDashboard = ({ handleUnitInfo }) => {
 					<th className="pr-5">LoadState</th>
 					<th className="pr-5">ActiveState</th>
 					<th className="pr-5">SubState</th>
+					<th className="pr-5">Restarts</th>
 					<th>Observation Time</th>
 					<th className="pr-5">Ready Status</th>
 				</tr>
@@ -106,6 +115,7 @@ const UnitDashboard = ({ handleUnitInfo }) => {
 							<td>{unit.LoadState}</td>
 							<td>{unit.ActiveState}</td>
 							<td>{unit.SubState}</td>
+							<td>{unit.NRestarts}</td>
 							<td>{unit.At}</td>
 							<ReadyStatus unit={unit}/>
 						</tr>
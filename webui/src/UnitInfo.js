@@ -1,7 +1,20 @@
-import React from "react";
+import React, { useState, useEffect } from "react";
 import './App.css';
 
-const UnitInfo = ({ unitInfo, close }) => {
+const UnitInfo = ({ unit, close }) => {
+	const journalEndpoint = `http://${window.location.hostname}:${window.location.port}/journal?unit=${encodeURIComponent(unit.Name)}`;
+	const actionEndpoint = `http://${window.location.hostname}:${window.location.port}/systemd/action`;
+	const [journal, setJournal] = useState([]);
+	const [actionStatus, setActionStatus] = useState("");
+	const unitInfo = unit.Properties;
+
+	useEffect(() => {
+		fetch(journalEndpoint)
+		.then(res => res.json())
+		.then(data => setJournal(data === null ? [] : data))
+		.catch((err) => setJournal([]));
+	}, [journalEndpoint]);
+
 	const formatProperty = (property) => {
 		if (Array.isArray(property)) {
 			return property.join(",\n");
@@ -10,14 +23,58 @@ const UnitInfo = ({ unitInfo, close }) => {
 		}
 	};
 
+	const BackButton = () => (
+		<button className="font-bold bg-gray-300 p-2 rounded hover:bg-gray-400 shadow-xl block mb-5" onClick={
+			(e) => {
+				close();
+			}
+		}>Back</button>
+	);
+
+	const runAction = (action) => {
+		setActionStatus(`Running ${action}...`);
+		fetch(actionEndpoint, {
+			method: "POST",
+			headers: { "Content-Type": "application/json" },
+			body: JSON.stringify({ unit: unit.Name, action: action }),
+		})
+		.then(res => res.text().then(text => setActionStatus(`${action}: ${text}`)))
+		.catch(err => setActionStatus(`${action}: ${err}`));
+	};
+
+	const UnitActions = () => (
+		<div className="mb-5">
+			{["start", "stop", "restart", "reset-failed"].map(action => (
+				<button key={action} className="font-bold bg-gray-300 p-2 mr-2 rounded hover:bg-gray-400 shadow-xl"
+					onClick={() => runAction(action)}>
+					{action}
+				</button>
+			))}
+			{actionStatus !== "" && <div className="mt-2">{actionStatus}</div>}
+		</div>
+	);
+
+	const UnitStatus = () => (
+		<div className="mb-5">
+			<div>{unit.Name}: <span className="font-bold">{unit.LoadState} {unit.ActiveState} {unit.SubState}</span></div>
+			{unit.StatusText !== "" && <div>Status: <span className="font-bold">{unit.StatusText}</span></div>}
+			{journal.length > 0 &&
+				<div className="mt-3">
+				<div className="font-bold">Recent journal lines:</div>
+				<pre className="text-xs whitespace-pre-wrap">
+					{journal.map(entry => `${entry.time} ${entry.message}`).join("\n")}
+				</pre>
+				</div>
+			}
+		</div>
+	);
+
 	if (unitInfo === null) {
 		return (
 			<div className="bg-blue-300 pl-4 pt-5 pb-10 h-screen">
-			<button className="font-bold bg-gray-300 p-2 rounded hover:bg-gray-400 shadow-xl block mb-5" onClick={
-				(e) => {
-					close();
-				}
-			}>Back</button>
+			<BackButton />
+			<UnitStatus />
+			<UnitActions />
 			<div>
 				Permission denied.
 			</div>
@@ -26,11 +83,9 @@ const UnitInfo = ({ unitInfo, close }) => {
 	} 
 	return (
 		<div className="bg-blue-300 pl-4 pt-5 pb-10">
-		<button className="font-bold bg-gray-300 p-2 rounded hover:bg-gray-400 shadow-xl block mb-5" onClick={
-			(e) => {
-				close();
-			}
-		}>Back</button>
+		<BackButton />
+		<UnitStatus />
+		<UnitActions />
 		<table className="table-auto w-4/5 m-auto shadow-xl ">
 			<thead>
 			<tr className="tableHeaderRow">